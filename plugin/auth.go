@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	nonceSize = 32
+	// signatureSuffix is appended to the path of a parasite executable to
+	// find its detached signature.
+	signatureSuffix = ".sig"
+)
+
+func newNonce() []byte {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return nonce
+}
+
+// proofMessage is what a parasite signs to prove it holds the key: the nonce
+// of the host followed by the name of the parasite.
+func proofMessage(nonce []byte, name string) []byte {
+	return append(append([]byte(nil), nonce...), name...)
+}
+
+// newProof answers the nonce of the host with the Ed25519 key of the
+// parasite if it has one, otherwise with the shared secret. It returns nil if
+// the parasite has neither.
+func newProof(nonce []byte, options *Options) []byte {
+	if len(nonce) == 0 {
+		return nil
+	}
+	message := proofMessage(nonce, options.Name)
+	if len(options.PrivateKey) == ed25519.PrivateKeySize {
+		return ed25519.Sign(options.PrivateKey, message)
+	}
+	if len(options.Secret) > 0 {
+		mac := hmac.New(sha256.New, options.Secret)
+		mac.Write(message)
+		return mac.Sum(nil)
+	}
+	return nil
+}
+
+// authenticates reports whether the host asks parasites for a proof.
+func (o *HostOptions) authenticates() bool {
+	return len(o.Secret) > 0 || len(o.TrustedKeys) > 0
+}
+
+// checkProof checks the proof a parasite answered nonce with against the
+// shared secret and the trusted keys of the host.
+func (o *HostOptions) checkProof(info *HandshakeInfo, nonce []byte) error {
+	if len(info.Proof) == 0 {
+		return fmt.Errorf("parasite %s sent no proof", info.Name)
+	}
+	message := proofMessage(nonce, info.Name)
+	if len(o.Secret) > 0 {
+		mac := hmac.New(sha256.New, o.Secret)
+		mac.Write(message)
+		if hmac.Equal(mac.Sum(nil), info.Proof) {
+			return nil
+		}
+	}
+	if len(info.Proof) == ed25519.SignatureSize {
+		for _, key := range o.TrustedKeys {
+			if ed25519.Verify(key, message, info.Proof) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("the proof of parasite %s does not match", info.Name)
+}
+
+// verifyBinary opens the executable at path and checks its content against
+// the checksum manifest and the signature keys of the host, if they are set.
+// It returns the open file, nil if there is nothing to check, for the parasite
+// to be executed from, see execVerified.
+func (o *HostOptions) verifyBinary(path string) (*os.File, error) {
+	if o.ChecksumFile == "" && len(o.SignatureKeys) == 0 {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = o.verifyContent(f, path); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (o *HostOptions) verifyContent(f *os.File, path string) error {
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	if o.ChecksumFile != "" {
+		checksums, err := readChecksums(o.ChecksumFile)
+		if err != nil {
+			return err
+		}
+		expected, ok := checksums[filepath.Base(path)]
+		if !ok {
+			return fmt.Errorf("%s is not in the checksum file", filepath.Base(path))
+		}
+		sum := sha256.Sum256(content)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), expected) {
+			return fmt.Errorf("checksum of %s does not match", filepath.Base(path))
+		}
+	}
+
+	if len(o.SignatureKeys) > 0 {
+		signature, err := os.ReadFile(path + signatureSuffix)
+		if err != nil {
+			return fmt.Errorf("read signature: %w", err)
+		}
+		signature = bytes.TrimSpace(signature)
+		if len(signature) != ed25519.SignatureSize {
+			// accept hex encoded signatures too
+			signature, err = hex.DecodeString(string(signature))
+			if err != nil {
+				return fmt.Errorf("signature of %s is malformed", filepath.Base(path))
+			}
+		}
+		verified := false
+		for _, key := range o.SignatureKeys {
+			if ed25519.Verify(key, content, signature) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return fmt.Errorf("signature of %s does not match", filepath.Base(path))
+		}
+	}
+	return nil
+}
+
+// skipFile reports whether a file of the parasite directory is part of the
+// verification rather than a parasite.
+func (o *HostOptions) skipFile(path string) bool {
+	if len(o.SignatureKeys) > 0 && strings.HasSuffix(path, signatureSuffix) {
+		return true
+	}
+	if o.ChecksumFile != "" {
+		checksumFile, err1 := filepath.Abs(o.ChecksumFile)
+		file, err2 := filepath.Abs(path)
+		return err1 == nil && err2 == nil && checksumFile == file
+	}
+	return false
+}
+
+// readChecksums reads a manifest in the format of sha256sum: a hex encoded
+// SHA-256 checksum and a file name on each line.
+func readChecksums(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum marks binary mode with a leading "*"
+		checksums[filepath.Base(strings.TrimPrefix(fields[1], "*"))] = fields[0]
+	}
+	return checksums, scanner.Err()
+}
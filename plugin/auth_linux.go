@@ -0,0 +1,16 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// execVerified makes cmd execute f, the executable verifyBinary checked,
+// through the descriptor the parasite inherits: replacing the file at its
+// path after the check does not change what runs.
+func execVerified(cmd *exec.Cmd, f *os.File) {
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	cmd.Path = fmt.Sprintf("/proc/self/fd/%d", fd)
+}
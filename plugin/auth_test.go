@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestCheckProof(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	host := &HostOptions{Secret: []byte("secret"), TrustedKeys: []ed25519.PublicKey{publicKey}}
+	nonce := newNonce()
+
+	tests := []struct {
+		options *Options
+		nonce   []byte
+		ok      bool
+	}{
+		{&Options{Name: "p", Secret: []byte("secret")}, nonce, true},
+		{&Options{Name: "p", PrivateKey: privateKey}, nonce, true},
+		{&Options{Name: "p", Secret: []byte("other")}, nonce, false},
+		{&Options{Name: "p", PrivateKey: otherKey}, nonce, false},
+		{&Options{Name: "p"}, nonce, false},
+		// a proof for another nonce can not be replayed
+		{&Options{Name: "p", Secret: []byte("secret")}, newNonce(), false},
+	}
+	for i, test := range tests {
+		info := &HandshakeInfo{Name: "p", Proof: newProof(test.nonce, test.options)}
+		if err := host.checkProof(info, nonce); (err == nil) != test.ok {
+			t.Errorf("test %d: expected ok %v, got %v", i, test.ok, err)
+		}
+	}
+
+	// the proof is bound to the name of the parasite
+	info := &HandshakeInfo{Name: "q", Proof: newProof(nonce, &Options{Name: "p", Secret: []byte("secret")})}
+	if err := host.checkProof(info, nonce); err == nil {
+		t.Error("a proof for another parasite should not match")
+	}
+}
+
+func TestVerifyBinary(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "parasite")
+	content := []byte("#!/bin/sh\n")
+	if err := os.WriteFile(path, content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	checksumFile := filepath.Join(dir, "SHA256SUMS")
+	manifest := fmt.Sprintf("%s  parasite\n%s  other\n", hex.EncodeToString(sum[:]), hex.EncodeToString(make([]byte, 32)))
+	if err := os.WriteFile(checksumFile, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(options *HostOptions) error {
+		f, err := options.verifyBinary(path)
+		if f != nil {
+			_ = f.Close()
+		}
+		return err
+	}
+	options := &HostOptions{ChecksumFile: checksumFile}
+	if err := verify(options); err != nil {
+		t.Error(err)
+	}
+	if !options.skipFile(checksumFile) || options.skipFile(path) {
+		t.Error("only the checksum file should be skipped")
+	}
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(options); err == nil {
+		t.Error("a modified executable should be refused")
+	}
+
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	options = &HostOptions{SignatureKeys: []ed25519.PublicKey{publicKey}}
+	if err := verify(options); err == nil {
+		t.Error("an executable without signature should be refused")
+	}
+	content, _ = os.ReadFile(path)
+	signature := hex.EncodeToString(ed25519.Sign(privateKey, content))
+	if err := os.WriteFile(path+signatureSuffix, []byte(signature+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(options); err != nil {
+		t.Error(err)
+	}
+	if !options.skipFile(path + signatureSuffix) {
+		t.Error("signatures should be skipped")
+	}
+}
+
+func TestExecVerified(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the checked file is only executed on linux")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "parasite")
+	content := []byte("#!/bin/sh\necho checked\n")
+	if err := os.WriteFile(path, content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	checksumFile := filepath.Join(dir, "SHA256SUMS")
+	if err := os.WriteFile(checksumFile, []byte(hex.EncodeToString(sum[:])+"  parasite\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := (&HostOptions{ChecksumFile: checksumFile}).verifyBinary(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmd := exec.Command(path)
+	execVerified(cmd, f)
+
+	// the executable is replaced between the check and the start
+	swapped := filepath.Join(dir, "swapped")
+	if err = os.WriteFile(swapped, []byte("#!/bin/sh\necho swapped\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(swapped, path); err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "checked\n" {
+		t.Errorf("the checked file should be executed, got %q", out)
+	}
+}
+
+func TestEntity_unauthenticatedCalls(t *testing.T) {
+	called := atomic.Bool{}
+	h := NewHostWithOptions(&HostOptions{
+		Name:    "host",
+		Version: "1.0.0",
+		Secret:  []byte("secret"),
+		Executor: executorFunc(func(call string, data []byte) ([]byte, error) {
+			called.Store(true)
+			return nil, nil
+		}),
+	})
+	// a peer sending a handshake without proof, then calls to the host
+	err := h.LoadFunc("intruder", func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error {
+		c := newConn(r, w)
+		data, _ := msgpack.Marshal(&HandshakeInfo{Name: "intruder", Version: "1.0.0"})
+		_ = c.send(&sendObject{call: callHandshake, content: data})
+		_ = c.send(&sendObject{id: 1, call: "steal"})
+		_ = c.send(&sendObject{call: callReady})
+		_ = c.send(&sendObject{id: 2, call: "steal"})
+		<-ctx.Done()
+		return nil
+	})
+	if !errors.Is(err, ErrParasiteRefused) {
+		t.Fatalf("the parasite should be refused, got %v", err)
+	}
+	if called.Load() {
+		t.Error("the calls of a refused parasite should not reach the Executor")
+	}
+}
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type callResponse struct {
+	err      error
+	content  []byte
+	metadata Metadata
+}
+
+// pendingCalls tracks the calls sent over a pipe that wait for a reply.
+type pendingCalls struct {
+	calls  map[uint64]chan *callResponse
+	locker sync.Mutex
+	lastID atomic.Uint64
+}
+
+func (p *pendingCalls) newID() uint64 {
+	return p.lastID.Add(1)
+}
+
+func (p *pendingCalls) add() (uint64, chan *callResponse) {
+	id := p.newID()
+	channel := make(chan *callResponse, 1)
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.calls == nil {
+		p.calls = make(map[uint64]chan *callResponse)
+	}
+	p.calls[id] = channel
+	return id, channel
+}
+
+// resolve delivers the reply frame r to the call waiting for it.
+func (p *pendingCalls) resolve(r *sendObject) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	channel, ok := p.calls[r.id]
+	if !ok {
+		return
+	}
+	delete(p.calls, r.id)
+	rsp := &callResponse{
+		err:      r.err,
+		content:  r.content,
+		metadata: r.metadata,
+	}
+	if r.remoteErr != nil {
+		rsp.err = r.remoteErr
+	}
+	channel <- rsp
+}
+
+func (p *pendingCalls) forget(id uint64) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	delete(p.calls, id)
+}
+
+// failAll ends every call waiting for a reply with err.
+func (p *pendingCalls) failAll(err error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	for id, channel := range p.calls {
+		channel <- &callResponse{
+			err: err,
+		}
+		delete(p.calls, id)
+	}
+}
+
+// roundTrip sends call over conn with the metadata of ctx and waits for the
+// reply until ctx is done, in which case the other side is told to abort the
+// call.
+func roundTrip(ctx context.Context, conn *conn, pending *pendingCalls, call string, data []byte) ([]byte, error) {
+	id, channel := pending.add()
+	err := conn.send(&sendObject{
+		id:       id,
+		call:     call,
+		content:  data,
+		metadata: requestMetadata(ctx),
+	})
+	if err != nil {
+		pending.forget(id)
+		return nil, err
+	}
+
+	select {
+	case rsp := <-channel:
+		// a reply racing the end of ctx does not hide it, the handler may
+		// reach the deadline sent with the call first
+		if err := ctx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return nil, contextError(context.DeadlineExceeded)
+		}
+		storeReplyMetadata(ctx, rsp.metadata)
+		return rsp.content, rsp.err
+	case <-ctx.Done():
+		pending.forget(id)
+		_ = conn.send(&sendObject{
+			id:   id,
+			call: callCancel,
+		})
+		return nil, contextError(ctx.Err())
+	}
+}
+
+// contextError wraps the error of a done context into ErrTimeout or
+// ErrCanceled.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", ErrCanceled, err)
+}
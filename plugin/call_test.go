@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func init() {
+	testParasites["abortable"] = func(options *Options) {
+		RegisterHandler("wait", abortable{})
+	}
+}
+
+// abortable is a ContextParasite whose calls last until they are aborted.
+type abortable struct{}
+
+func (abortable) Init() error {
+	return nil
+}
+
+func (abortable) UnInit() {}
+
+func (abortable) Handle(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (abortable) HandleContext(ctx context.Context, data []byte) ([]byte, error) {
+	recordEvent("started")
+	<-ctx.Done()
+	recordEvent("aborted")
+	return nil, ctx.Err()
+}
+
+func TestEntity_CallWithResponseContext(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	dir := loadTestParasites(t, h, "abortable")
+	e, ok := h.Parasite("abortable")
+	if !ok {
+		t.Fatal("the parasite should be loaded")
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := e.CallWithResponseContext(ctx, "wait", nil)
+		if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("the call should fail with ErrTimeout, got %v", err)
+		}
+		waitFor(t, "the handler to be aborted", func() bool {
+			return countEvents(dir, "aborted") == 1
+		})
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		// only the cancel sent by the host ends the handler, the timeout
+		// keeps the test from hanging
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		go func() {
+			for countEvents(dir, "started") < 2 {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+		}()
+		_, err := e.CallWithResponseContext(ctx, "wait", nil)
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("the call should fail with ErrCanceled, got %v", err)
+		}
+		waitFor(t, "the handler to be canceled", func() bool {
+			return countEvents(dir, "aborted") == 2
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec encodes the requests and replies of typed calls, see Register and
+// Invoke.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string                       { return "msgpack" }
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+var (
+	MsgpackCodec Codec = msgpackCodec{}
+	JSONCodec    Codec = jsonCodec{}
+)
+
+var (
+	codecs      = map[string]Codec{}
+	codecNames  []string
+	codecLocker sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(MsgpackCodec)
+	RegisterCodec(JSONCodec)
+}
+
+// RegisterCodec makes c available to the codec negotiation. The host offers
+// the codecs in the order they are registered unless HostOptions.Codecs is
+// set, and the parasite picks the first one it has registered too.
+func RegisterCodec(c Codec) {
+	codecLocker.Lock()
+	defer codecLocker.Unlock()
+	if _, ok := codecs[c.Name()]; !ok {
+		codecNames = append(codecNames, c.Name())
+	}
+	codecs[c.Name()] = c
+}
+
+func CodecByName(name string) (Codec, bool) {
+	codecLocker.RLock()
+	defer codecLocker.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+func registeredCodecNames() []string {
+	codecLocker.RLock()
+	defer codecLocker.RUnlock()
+	return append([]string(nil), codecNames...)
+}
+
+// pickCodec returns the first registered codec offered by the host, msgpack if
+// there is none.
+func pickCodec(offered []string) Codec {
+	for _, name := range offered {
+		if c, ok := CodecByName(name); ok {
+			return c
+		}
+	}
+	return MsgpackCodec
+}
+
+type codecKey struct{}
+
+func withCodec(ctx context.Context, c Codec) context.Context {
+	return context.WithValue(ctx, codecKey{}, c)
+}
+
+// CodecFromContext returns the codec negotiated with the host for the call
+// being handled, msgpack if there is none.
+func CodecFromContext(ctx context.Context) Codec {
+	if c, ok := ctx.Value(codecKey{}).(Codec); ok {
+		return c
+	}
+	return MsgpackCodec
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type typedRequest struct {
+	A int
+	B int
+}
+
+type typedReply struct {
+	Sum int
+}
+
+func TestTypedHandler(t *testing.T) {
+	h := &typedHandler[typedRequest, typedReply]{
+		fn: func(ctx context.Context, req typedRequest) (typedReply, error) {
+			if req.A < 0 {
+				return typedReply{}, ErrUnknownCall
+			}
+			return typedReply{Sum: req.A + req.B}, nil
+		},
+	}
+	for _, codec := range []Codec{MsgpackCodec, JSONCodec} {
+		ctx := withCodec(context.Background(), codec)
+		data, err := codec.Marshal(&typedRequest{A: 1, B: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = h.HandleContext(ctx, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp := typedReply{}
+		if err = codec.Unmarshal(data, &rsp); err != nil {
+			t.Fatal(err)
+		}
+		if rsp.Sum != 3 {
+			t.Errorf("%s: expected 3, got %d", codec.Name(), rsp.Sum)
+		}
+
+		data, _ = codec.Marshal(&typedRequest{A: -1})
+		if _, err = h.HandleContext(ctx, data); !errors.Is(err, ErrUnknownCall) {
+			t.Errorf("%s: the error of the handler should be returned, got %v", codec.Name(), err)
+		}
+	}
+}
+
+func TestPickCodec(t *testing.T) {
+	if pickCodec([]string{"unknown", "json", "msgpack"}) != JSONCodec {
+		t.Errorf("should pick the first registered codec")
+	}
+	if pickCodec(nil) != MsgpackCodec {
+		t.Errorf("should fall back to msgpack")
+	}
+}
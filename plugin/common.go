@@ -3,131 +3,192 @@ package plugin
 import (
 	"bufio"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"strconv"
-	"strings"
 	"sync"
 
 	"github.com/vmihailenco/msgpack"
 )
 
 const (
-	orderPrefix = "\n\n__mfk_parasite_order__"
-	splitter    = "|"
-	callLogger  = "logger"
-	callReply   = "_reply"
-)
+	callLogger    = "logger"
+	callReply     = "_reply"
+	callHandshake = "_handshake"
+	callCancel    = "_cancel"
+	callShutdown  = "_shutdown"
+	callReady     = "_ready"
+	callPing      = "_ping"
+
+	// callStreamOpen opens a stream for the call named by its content, the
+	// other frames of the stream only carry its id
+	callStreamOpen   = "_stream_open"
+	callStreamData   = "_stream_data"
+	callStreamEnd    = "_stream_end"
+	callStreamCredit = "_stream_credit"
 
-var safeCallCache sync.Map
-var locker sync.Mutex
+	// envRPCFDs holds the file descriptors of the dedicated pipe pair a
+	// parasite reads and writes frames on, as "in,out".
+	envRPCFDs = "MFK_PARASITE_RPC_FDS"
+	// envSandboxLimits passes the resource limits of a parasite to the host
+	// executable run in its place, as "resource=value" pairs separated by
+	// commas.
+	envSandboxLimits = "MFK_SANDBOX_LIMITS"
+)
 
 type sendObject struct {
 	id      uint64
 	call    string
 	content []byte
-	err     error
+	// remoteErr is the error the sender reports for the call
+	remoteErr *RemoteError
+	metadata  Metadata
+	// err is set when the frame could not be decoded
+	err error
 }
 
-func send(writer io.Writer, r *sendObject) error {
-	safeData := base64.StdEncoding.EncodeToString(r.content)
-	safeCall := ""
-	t, loaded := safeCallCache.Load(r.call)
-	if loaded {
-		safeCall = t.(string)
-	} else {
-		safeCall = base64.StdEncoding.EncodeToString([]byte(r.call))
-		safeCallCache.Store(r.call, safeCall)
-	}
+// conn is one side of the pipe between the host and a parasite. Frames are
+// written with the text framing until both sides agreed on another one during
+// the handshake.
+type conn struct {
+	reader *bufio.Reader
+	writer io.Writer
 
-	locker.Lock()
-	defer locker.Unlock()
+	readFraming  framing
+	writeFraming framing
+	// peeked is the frame peek read, returned by the next read
+	peeked *sendObject
+	// sendMetadata is set once the other side said it reads metadata
+	sendMetadata bool
+	writeLocker  sync.Mutex
+}
 
-	if _, err := fmt.Fprintln(writer, orderPrefix); err != nil {
-		return err
-	}
-	if _, err := fmt.Fprintln(writer, strconv.FormatUint(r.id, 10)); err != nil {
-		return err
-	}
-	if _, err := fmt.Fprintln(writer, splitter); err != nil {
-		return err
-	}
-	if _, err := fmt.Fprintln(writer, safeCall); err != nil {
-		return err
+func newConn(reader io.Reader, writer io.Writer) *conn {
+	return &conn{
+		reader:       bufio.NewReader(reader),
+		writer:       writer,
+		readFraming:  textFraming{},
+		writeFraming: textFraming{},
 	}
-	if _, err := fmt.Fprintln(writer, splitter); err != nil {
-		return err
+}
+
+func (c *conn) send(r *sendObject) error {
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+	if len(r.metadata) > 0 && !c.sendMetadata {
+		withoutMetadata := *r
+		withoutMetadata.metadata = nil
+		r = &withoutMetadata
 	}
-	if _, err := fmt.Fprintln(writer, safeData); err != nil {
-		return err
+	return c.writeFraming.write(c.writer, r)
+}
+
+// read must only be called from one goroutine, which is also the only one
+// allowed to call setReadFraming.
+func (c *conn) read() (*sendObject, error) {
+	if c.peeked != nil {
+		r := c.peeked
+		c.peeked = nil
+		return r, nil
 	}
-	return nil
+	return c.readFraming.read(c.reader)
 }
 
-func read(reader *bufio.Reader) (*sendObject, error) {
-	line := ""
-	for {
-		p, prefix, err := reader.ReadLine()
+// peek reads the next frame without consuming it, from the goroutine that
+// reads.
+func (c *conn) peek() (*sendObject, error) {
+	if c.peeked == nil {
+		r, err := c.readFraming.read(c.reader)
 		if err != nil {
 			return nil, err
 		}
-		line += string(p)
-		if prefix {
-			continue
-		}
-		t := line
-		line = ""
-		if strings.HasPrefix(t, orderPrefix) {
-			parts := strings.Split(t[len(orderPrefix):], splitter)
-			if len(parts) != 3 {
-				continue
-			}
-
-			rsp := &sendObject{}
-			rsp.id, err = strconv.ParseUint(parts[0], 10, 0)
-			if err != nil {
-				rsp.err = fmt.Errorf("decode id failed: %w", err)
-				return rsp, nil
-			}
-
-			call, err := base64.StdEncoding.DecodeString(parts[1])
-			if err != nil {
-				rsp.err = fmt.Errorf("decode call failed: %w", err)
-				return rsp, nil
-			}
-			rsp.call = string(call)
-			rsp.content, err = base64.StdEncoding.DecodeString(parts[2])
-			if err != nil {
-				rsp.err = fmt.Errorf("decode content failed: %w", err)
-				return rsp, nil
-			}
-			return rsp, nil
-		}
+		c.peeked = r
 	}
+	return c.peeked, nil
+}
+
+func (c *conn) setReadFraming(f framing) {
+	c.readFraming = f
 }
 
+func (c *conn) setWriteFraming(f framing) {
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+	c.writeFraming = f
+}
+
+func (c *conn) enableMetadata() {
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+	c.sendMetadata = true
+}
+
+// HandshakeInfo is sent by the host to a parasite on its command line and
+// sent back by the parasite over the pipe once it has started. Calls is only
+// filled by the parasite and lists the names given to RegisterHandler.
+// Framings and Codecs list the frame formats and codecs the host supports,
+// the parasite answers with the ones it picked.
 type HandshakeInfo struct {
-	Name    string
-	Version string
+	Name     string
+	Version  string
+	Calls    []string
+	Framings []string
+	Codecs   []string
+	// Nonce is sent by a host that authenticates its parasites, which answer
+	// it with a Proof.
+	Nonce []byte
+	Proof []byte
+	// Metadata is set by a host that reads metadata in frames, and by a
+	// parasite answering such a host. Metadata is only sent once both sides
+	// set it.
+	Metadata bool
 }
 
-func checkHandshake(handshake string, options *Options) bool {
+// errNoHandshake is returned by checkHandshake when the parasite is not
+// started by a host.
+var errNoHandshake = errors.New("no handshake from the host")
+
+// checkHandshake decodes the handshake the host passes with -h and checks the
+// host against the options, returning ErrHostRefused with the reason if the
+// parasite does not accept it.
+func checkHandshake(handshake string, options *Options) (*HandshakeInfo, error) {
 	data, err := base64.StdEncoding.DecodeString(handshake)
-	if err != nil {
-		return false
+	if err != nil || handshake == "" {
+		return nil, errNoHandshake
 	}
 	info := &HandshakeInfo{}
 	err = msgpack.Unmarshal(data, info)
 	if err != nil {
-		return false
+		return nil, errNoHandshake
 	}
 	if info.Name != options.HostName {
-		return false
+		return nil, fmt.Errorf("%w: host is %s, not %s", ErrHostRefused, info.Name, options.HostName)
 	}
 
-	if info.Version < options.HostMinimalVersion {
-		return false
+	version, err := ParseVersion(info.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrHostRefused, err)
+	}
+	if options.HostMinimalVersion != "" {
+		minimal, err := ParseVersion(options.HostMinimalVersion)
+		if err != nil {
+			return nil, fmt.Errorf("%w: host minimal version: %w", ErrHostRefused, err)
+		}
+		if version.Compare(minimal) < 0 {
+			return nil, fmt.Errorf("%w: host version %s is older than %s",
+				ErrHostRefused, info.Version, options.HostMinimalVersion)
+		}
+	}
+	if options.HostVersionConstraint != "" {
+		constraint, err := ParseVersionConstraint(options.HostVersionConstraint)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrHostRefused, err)
+		}
+		if !constraint.Check(version) {
+			return nil, fmt.Errorf("%w: host version %s does not satisfy %s",
+				ErrHostRefused, info.Version, constraint)
+		}
 	}
 
-	return true
+	return info, nil
 }
@@ -3,172 +3,713 @@ package plugin
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/delichik/daf/logger"
+	"github.com/vmihailenco/msgpack"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	// killTimeout is how long Shutdown waits after SIGTERM before it kills
+	// the parasite
+	killTimeout = 5 * time.Second
+	// readTimeout is how long the frames written by an exited process are
+	// read for, processes it started may keep its pipe open
+	readTimeout = time.Second
 )
 
-type callRequest struct {
-	channel chan *callResponse
-	addTime int64
+// process is one run of the parasite, an executable or a function of the
+// host.
+type process struct {
+	runner    runner
+	conn      *conn
+	closers   []io.Closer
+	startTime time.Time
+	exited    chan struct{}
+	// readDone is closed once the frames of the process are all read
+	readDone chan struct{}
+}
+
+// runner is how a process runs.
+type runner interface {
+	// wait waits for the parasite to exit and returns its exit code, -1 if it
+	// was killed
+	wait() int
+	// terminate asks the parasite to exit as SIGTERM does
+	terminate() error
+	kill() error
+}
+
+// cmdRunner runs a parasite executable, in its own process group if group
+// is set.
+type cmdRunner struct {
+	cmd   *exec.Cmd
+	group bool
+}
+
+func (r *cmdRunner) wait() int {
+	_ = r.cmd.Wait()
+	if r.group {
+		// the processes the parasite started do not outlive it
+		_ = signalGroup(r.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return r.cmd.ProcessState.ExitCode()
+}
+
+func (r *cmdRunner) terminate() error {
+	if r.group {
+		return signalGroup(r.cmd.Process.Pid, syscall.SIGTERM)
+	}
+	return r.cmd.Process.Signal(syscall.SIGTERM)
 }
-type callResponse struct {
-	err     error
-	content []byte
+
+func (r *cmdRunner) kill() error {
+	if r.group {
+		return signalGroup(r.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return r.cmd.Process.Kill()
+}
+
+// wait waits for the process to exit, releases its pipes and returns its exit
+// code.
+func (p *process) wait() int {
+	exitCode := p.runner.wait()
+	// the replies written right before the exit may not be read yet
+	if p.readDone != nil {
+		timer := time.NewTimer(readTimeout)
+		select {
+		case <-p.readDone:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	for _, closer := range p.closers {
+		_ = closer.Close()
+	}
+	close(p.exited)
+	return exitCode
+}
+
+// spawner starts a new process of the parasite of e, passing it handshake.
+type spawner func(e *Entity, handshake string) (*process, error)
+
+// cmdSpawner runs the executables newCmd returns, in the sandbox of the
+// parasite.
+func cmdSpawner(newCmd func(handshake string) (*exec.Cmd, error)) spawner {
+	return func(e *Entity, handshake string) (*process, error) {
+		cmd, err := newCmd(handshake)
+		if err != nil {
+			return nil, err
+		}
+		// the files newCmd passes on belong to the parasite once it starts
+		defer func(files []*os.File) {
+			for _, f := range files {
+				_ = f.Close()
+			}
+		}(cmd.ExtraFiles)
+		sandbox := e.host.sandbox(e.name)
+		if err = sandbox.apply(cmd); err != nil {
+			return nil, err
+		}
+		proc := &process{
+			runner: &cmdRunner{cmd: cmd, group: sandbox != nil && sandbox.ProcessGroup},
+			exited: make(chan struct{}),
+		}
+		if e.host.options.StdioTransport || runtime.GOOS == "windows" {
+			err = proc.startWithStdio(cmd)
+		} else {
+			err = e.startWithPipes(proc, cmd)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return proc, nil
+	}
 }
 
 type Entity struct {
-	name string
-	cmd  *exec.Cmd
-	host *Host
+	name  string
+	spawn spawner
+	host  *Host
 
+	// ctx is canceled once the parasite is stopped for good
 	ctx    context.Context
 	cancel context.CancelFunc
+	// stopping is set by Stop and Shutdown so that the parasite is not
+	// restarted when it exits
+	stopping atomic.Bool
+	// attached is set for a parasite that connected to the host, which can
+	// not restart it
+	attached bool
+
+	proc       *process
+	info       *HandshakeInfo
+	procLocker sync.RWMutex
 
-	parasiteOutput io.ReadCloser
-	parasiteInput  io.WriteCloser
-	stdoutBuffered *bufio.Reader
+	pending pendingCalls
+	streams streamSet
+	// inflight cancels the calls of the parasite the host is handling
+	inflight       map[inflightCall]context.CancelFunc
+	inflightLocker sync.Mutex
 
-	calls       map[uint64]*callRequest
-	callLocker  sync.RWMutex
-	callIDIndex atomic.Uint64
+	metrics    entityMetrics
+	unhealthy  atomic.Bool
+	logLimiter logLimiter
 }
 
-func newEntity(name string, cmd *exec.Cmd, host *Host) *Entity {
+func newEntity(name string, spawn spawner, host *Host) *Entity {
 	ctx, cancel := context.WithCancel(context.Background())
 	e := &Entity{
 		name:   name,
-		cmd:    cmd,
+		spawn:  spawn,
 		host:   host,
 		ctx:    ctx,
 		cancel: cancel,
-		calls:  map[uint64]*callRequest{},
 	}
 	return e
 }
 
-func (e *Entity) Start() error {
-	var err error
-	e.parasiteOutput, err = e.cmd.StdoutPipe()
-	if err != nil {
-		return err
+// Name returns the name the parasite advertised during the handshake.
+func (e *Entity) Name() string {
+	e.procLocker.RLock()
+	defer e.procLocker.RUnlock()
+	if e.info == nil {
+		return ""
+	}
+	return e.info.Name
+}
+
+// Version returns the version the parasite advertised during the handshake.
+func (e *Entity) Version() string {
+	e.procLocker.RLock()
+	defer e.procLocker.RUnlock()
+	if e.info == nil {
+		return ""
+	}
+	return e.info.Version
+}
+
+// Calls returns the call names the parasite registered handlers for.
+func (e *Entity) Calls() []string {
+	e.procLocker.RLock()
+	defer e.procLocker.RUnlock()
+	if e.info == nil {
+		return nil
+	}
+	return append([]string(nil), e.info.Calls...)
+}
+
+// Codec returns the codec negotiated with the parasite for typed calls.
+func (e *Entity) Codec() Codec {
+	e.procLocker.RLock()
+	defer e.procLocker.RUnlock()
+	if e.info == nil || len(e.info.Codecs) == 0 {
+		return MsgpackCodec
 	}
-	e.parasiteInput, err = e.cmd.StdinPipe()
+	if c, ok := CodecByName(e.info.Codecs[0]); ok {
+		return c
+	}
+	return MsgpackCodec
+}
+
+// Start runs the parasite and keeps it running until Stop is called,
+// restarting it according to the restart policy of the host when it exits.
+func (e *Entity) Start() error {
+	proc, err := e.startProcess()
 	if err != nil {
 		return err
 	}
-	e.stdoutBuffered = bufio.NewReader(e.parasiteOutput)
+	e.host.emit(&Event{Type: EventStarted, Parasite: e.name})
+	go e.supervise(proc)
+	go e.checkHealth()
+	return nil
+}
 
-	err = e.cmd.Start()
+// startProcess runs a new process of the parasite and waits for its
+// handshake.
+func (e *Entity) startProcess() (*process, error) {
+	var nonce []byte
+	if e.host.options.authenticates() {
+		nonce = newNonce()
+	}
+	proc, err := e.spawn(e, e.host.handshake(nonce))
 	if err != nil {
-		return err
+		e.host.emit(&Event{Type: EventFailed, Parasite: e.name, Err: err})
+		return nil, err
 	}
+	proc.startTime = time.Now()
+	proc.readDone = make(chan struct{})
 
+	handshaked := make(chan *handshakeResult, 1)
+	ready := make(chan error, 1)
 	go func() {
-		for {
-			rsp, err := read(e.stdoutBuffered)
-			if err != nil || e.ctx.Err() != nil {
-				return
+		defer close(proc.readDone)
+		e.readLoop(proc.conn, nonce, handshaked, ready)
+	}()
+
+	fail := func(err error) (*process, error) {
+		_ = proc.runner.kill()
+		proc.wait()
+		e.host.emit(&Event{Type: EventFailed, Parasite: e.name, Err: err})
+		return nil, err
+	}
+
+	timer := time.NewTimer(handshakeTimeout)
+	defer timer.Stop()
+	var info *HandshakeInfo
+	select {
+	case result := <-handshaked:
+		if result.err != nil {
+			return fail(fmt.Errorf("%w: %w", ErrHandshake, result.err))
+		}
+		info = result.info
+	case <-proc.readDone:
+		return fail(fmt.Errorf("%w: parasite closed its output", ErrHandshake))
+	case <-timer.C:
+		return fail(fmt.Errorf("%w: timed out", ErrHandshake))
+	}
+	// the parasite runs Init after the handshake, it may call the host
+	// meanwhile
+	select {
+	case err := <-ready:
+		if err != nil {
+			return fail(fmt.Errorf("%w: %w", ErrParasiteInit, err))
+		}
+	case <-proc.readDone:
+		// the error of a failed Init is read right before the output closes
+		select {
+		case err := <-ready:
+			if err != nil {
+				return fail(fmt.Errorf("%w: %w", ErrParasiteInit, err))
 			}
+		default:
+		}
+		return fail(fmt.Errorf("%w: parasite closed its output during init", ErrHandshake))
+	case <-timer.C:
+		return fail(fmt.Errorf("%w: init timed out", ErrHandshake))
+	}
 
-			if strings.HasSuffix(rsp.call, callReply) {
-				e.callLocker.Lock()
-				req, ok := e.calls[rsp.id]
-				if ok {
-					delete(e.calls, rsp.id)
-					select {
-					case req.channel <- &callResponse{
-						err:     rsp.err,
-						content: rsp.content,
-					}:
-					case <-e.ctx.Done():
-						return
-					default:
-					}
-					close(req.channel)
+	e.procLocker.Lock()
+	e.proc = proc
+	e.info = info
+	e.procLocker.Unlock()
+	if err := e.host.accept(e); err != nil {
+		e.procLocker.Lock()
+		e.proc = nil
+		e.procLocker.Unlock()
+		return fail(err)
+	}
+	return proc, nil
+}
+
+// handshakeResult is the handshake a parasite sends back, or the reason it
+// refuses the host.
+type handshakeResult struct {
+	info *HandshakeInfo
+	err  error
+}
+
+// readLoop reads the frames of the parasite. Nothing but its handshake is
+// handled until the handshake passes the checks of the host, so that a
+// parasite the host refuses never reaches the Executor.
+func (e *Entity) readLoop(conn *conn, nonce []byte, handshaked chan *handshakeResult, ready chan error) {
+	checked := false
+	for {
+		rsp, err := conn.read()
+		if err != nil {
+			return
+		}
+
+		if rsp.call == callHandshake {
+			if rsp.remoteErr != nil {
+				select {
+				case handshaked <- &handshakeResult{err: rsp.remoteErr}:
+				default:
 				}
-				e.callLocker.Unlock()
 				continue
 			}
-			e.host.dispatchCall(e, rsp.call, rsp.content, e.newReplyFunc(rsp.id, rsp.call))
+			info := &HandshakeInfo{}
+			if err := msgpack.Unmarshal(rsp.content, info); err != nil {
+				return
+			}
+			if checked {
+				// the handshake is only checked once
+				continue
+			}
+			if err := e.host.checkParasite(info, nonce); err != nil {
+				select {
+				case handshaked <- &handshakeResult{err: err}:
+				default:
+				}
+				continue
+			}
+			checked = true
+			if len(info.Framings) > 0 {
+				if f, ok := framingByName(info.Framings[0]); ok {
+					conn.setReadFraming(f)
+					conn.setWriteFraming(f)
+				}
+			}
+			if info.Metadata {
+				conn.enableMetadata()
+			}
+			select {
+			case handshaked <- &handshakeResult{info: info}:
+			default:
+			}
+			continue
+		}
+		if !checked {
+			continue
 		}
-	}()
 
-	go func() {
-		timer := time.NewTimer(500 * time.Millisecond)
-		defer timer.Stop()
-		for {
+		switch {
+		case rsp.call == callReady:
+			// a parasite whose Init failed reports the error instead
+			var err error
+			if rsp.remoteErr != nil {
+				err = rsp.remoteErr
+			}
 			select {
-			case <-timer.C:
-				e.callLocker.Lock()
-				for id, req := range e.calls {
-					if time.Now().Unix()-req.addTime > 5 {
-						close(req.channel)
-						delete(e.calls, id)
-					}
-				}
-				e.callLocker.Unlock()
-				timer.Reset(500 * time.Millisecond)
-			case <-e.ctx.Done():
-				return
+			case ready <- err:
+			default:
 			}
+			continue
+		case rsp.call == callCancel:
+			e.cancelCall(conn, rsp.id)
+			continue
+		case strings.HasSuffix(rsp.call, callReply):
+			e.pending.resolve(rsp)
+			continue
+		case isStreamFrame(rsp.call):
+			if s, ok := e.streams.get(rsp.id); ok {
+				s.deliver(rsp)
+			}
+			continue
 		}
-	}()
+		e.host.dispatchCall(e, conn, rsp)
+	}
+}
+
+// inflightCall identifies a call of the parasite by the pipe it was read from,
+// the ids of a restarted process starting over.
+type inflightCall struct {
+	conn *conn
+	id   uint64
+}
+
+// trackCall lets the parasite abort its call id read from conn with cancel,
+// until the returned function is called.
+func (e *Entity) trackCall(conn *conn, id uint64, cancel context.CancelFunc) func() {
+	key := inflightCall{conn: conn, id: id}
+	e.inflightLocker.Lock()
+	defer e.inflightLocker.Unlock()
+	if e.inflight == nil {
+		e.inflight = map[inflightCall]context.CancelFunc{}
+	}
+	e.inflight[key] = cancel
+	return func() {
+		e.inflightLocker.Lock()
+		defer e.inflightLocker.Unlock()
+		delete(e.inflight, key)
+	}
+}
+
+func (e *Entity) cancelCall(conn *conn, id uint64) {
+	e.inflightLocker.Lock()
+	defer e.inflightLocker.Unlock()
+	if cancel, ok := e.inflight[inflightCall{conn: conn, id: id}]; ok {
+		cancel()
+	}
+}
+
+// currentConn returns the pipe to the running process, nil while the
+// parasite is not running.
+func (e *Entity) currentConn() *conn {
+	e.procLocker.RLock()
+	defer e.procLocker.RUnlock()
+	if e.proc == nil {
+		return nil
+	}
+	return e.proc.conn
+}
+
+// startWithStdio runs the parasite with the RPC frames mixed into its
+// standard input and output.
+func (p *process) startWithStdio(cmd *exec.Cmd) error {
+	parasiteOutput, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	parasiteInput, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	p.conn = newConn(parasiteOutput, parasiteInput)
+	return cmd.Start()
+}
+
+// startWithPipes runs the parasite with a dedicated pipe pair for the RPC
+// frames, passed as extra files. What the parasite prints to its stdout and
+// stderr is forwarded to the logger.
+func (e *Entity) startWithPipes(p *process, cmd *exec.Cmd) error {
+	hostReader, parasiteWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	parasiteReader, hostWriter, err := os.Pipe()
+	if err != nil {
+		_ = hostReader.Close()
+		_ = parasiteWriter.Close()
+		return err
+	}
+	closeAll := func() {
+		_ = hostReader.Close()
+		_ = parasiteWriter.Close()
+		_ = parasiteReader.Close()
+		_ = hostWriter.Close()
+	}
+
+	// extra files start right after stdin, stdout and stderr
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, parasiteReader, parasiteWriter)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d,%d", envRPCFDs, fd, fd+1))
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		closeAll()
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		closeAll()
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		closeAll()
+		return err
+	}
+	// the parasite holds its own copies now
+	_ = parasiteReader.Close()
+	_ = parasiteWriter.Close()
 
+	p.conn = newConn(hostReader, hostWriter)
+	p.closers = append(p.closers, hostReader, hostWriter)
+	go e.forwardOutput("stdout", stdout)
+	go e.forwardOutput("stderr", stderr)
 	return nil
 }
 
-func (e *Entity) newReplyFunc(id uint64, cmd string) func(data []byte) error {
-	return func(data []byte) error {
-		return send(e.parasiteInput, &sendObject{
-			id:      id,
-			call:    cmd + callReply,
-			content: data,
+func (e *Entity) forwardOutput(stream string, output io.Reader) {
+	reader := bufio.NewReader(output)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			level := zapcore.InfoLevel
+			if stream == "stderr" {
+				level = zapcore.WarnLevel
+			}
+			e.forwardLog(&LogEntry{
+				Parasite: e.name,
+				Level:    level,
+				Time:     time.Now(),
+				Message:  line,
+				Fields:   map[string]interface{}{"stream": stream},
+			})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func newReplyFunc(conn *conn, id uint64, cmd string) func(data []byte, md Metadata, err error) error {
+	return func(data []byte, md Metadata, err error) error {
+		return conn.send(&sendObject{
+			id:        id,
+			call:      cmd + callReply,
+			content:   data,
+			remoteErr: toRemoteError(err),
+			metadata:  md,
 		})
 	}
 }
 
+// Stop kills the parasite, it is not restarted afterwards.
 func (e *Entity) Stop() error {
+	e.stopping.Store(true)
 	e.cancel()
-	return e.cmd.Process.Kill()
+	e.procLocker.RLock()
+	proc := e.proc
+	e.procLocker.RUnlock()
+	if proc == nil {
+		return nil
+	}
+	return proc.runner.kill()
 }
 
-func (e *Entity) Call(call string, data []byte) error {
-	req := &sendObject{
-		id:      e.callIDIndex.Add(1),
-		call:    call,
-		content: data,
+// Shutdown asks the parasite to finish the calls it is handling, run UnInit
+// and exit, new calls fail with ErrStopped meanwhile. If the parasite has not
+// exited when ctx is done, it is sent SIGTERM and killed if it still has not
+// exited killTimeout later. The parasite is not restarted afterwards.
+func (e *Entity) Shutdown(ctx context.Context) error {
+	e.stopping.Store(true)
+	e.procLocker.RLock()
+	proc := e.proc
+	e.procLocker.RUnlock()
+	if proc == nil {
+		e.cancel()
+		return nil
+	}
+
+	_ = proc.conn.send(&sendObject{
+		call: callShutdown,
+	})
+	select {
+	case <-proc.exited:
+		return nil
+	case <-ctx.Done():
+	}
+
+	logger.Warn("parasite did not exit in time, terminating", zap.String("parasite_name", e.name))
+	if err := proc.runner.terminate(); err == nil {
+		timer := time.NewTimer(killTimeout)
+		defer timer.Stop()
+		select {
+		case <-proc.exited:
+			return fmt.Errorf("parasite %s terminated: %w", e.name, ctx.Err())
+		case <-timer.C:
+		}
 	}
-	return send(e.parasiteInput, req)
+	_ = proc.runner.kill()
+	<-proc.exited
+	return fmt.Errorf("parasite %s killed: %w", e.name, ctx.Err())
+}
+
+func (e *Entity) Call(call string, data []byte) (err error) {
+	start := e.startCall(call)
+	defer func() {
+		e.finishCall(call, start, len(data), 0, err)
+	}()
+	info := &CallInfo{Parasite: e.name, Call: call, Notice: true}
+	_, err = intercept(context.Background(), e.host.outgoing, info, data,
+		func(ctx context.Context, data []byte) ([]byte, error) {
+			if e.stopping.Load() {
+				return nil, ErrStopped
+			}
+			conn := e.currentConn()
+			if conn == nil {
+				return nil, ErrParasiteExited
+			}
+			return nil, conn.send(&sendObject{
+				id:      e.pending.newID(),
+				call:    call,
+				content: data,
+			})
+		})
+	return err
 }
 
+// CallWithResponse sends call to the parasite and waits for its reply for at
+// most the default timeout of the host.
 func (e *Entity) CallWithResponse(call string, data []byte) ([]byte, error) {
-	req := &sendObject{
-		id:      e.callIDIndex.Add(1),
-		call:    call,
-		content: data,
-	}
-	channel := make(chan *callResponse, 1)
-	e.callLocker.Lock()
-	e.calls[req.id] = &callRequest{
-		channel: channel,
-		addTime: time.Now().Unix(),
-	}
-	e.callLocker.Unlock()
-	err := send(e.parasiteInput, req)
+	ctx, cancel := context.WithTimeout(context.Background(), e.host.defaultTimeout())
+	defer cancel()
+	return e.CallWithResponseContext(ctx, call, data)
+}
+
+// CallWithResponseContext sends call to the parasite and waits for its reply
+// until ctx is done. The parasite is told to abort the call when ctx is done
+// first, and the returned error wraps ErrTimeout or ErrCanceled along with the
+// error of ctx. Errors reported by the parasite are returned as *RemoteError,
+// and the call fails with ErrParasiteExited when the parasite exits before it
+// replies.
+func (e *Entity) CallWithResponseContext(ctx context.Context, call string, data []byte) (rsp []byte, err error) {
+	start := e.startCall(call)
+	defer func() {
+		e.finishCall(call, start, len(data), len(rsp), err)
+	}()
+	info := &CallInfo{Parasite: e.name, Call: call}
+	return intercept(ctx, e.host.outgoing, info, data, func(ctx context.Context, data []byte) ([]byte, error) {
+		if e.stopping.Load() {
+			return nil, ErrStopped
+		}
+		conn := e.currentConn()
+		if conn == nil {
+			return nil, ErrParasiteExited
+		}
+		return roundTrip(ctx, conn, &e.pending, call, data)
+	})
+}
+
+// OpenStream opens a stream to the handler the parasite registered for call
+// with RegisterStreamHandler. The stream is canceled when ctx is done.
+func (e *Entity) OpenStream(ctx context.Context, call string) (*Stream, error) {
+	if e.stopping.Load() {
+		return nil, ErrStopped
+	}
+	conn := e.currentConn()
+	if conn == nil {
+		return nil, ErrParasiteExited
+	}
+	s := newStream(ctx, e.pending.newID(), call, conn, true)
+	e.streams.add(s)
+	err := conn.send(&sendObject{
+		id:      s.id,
+		call:    callStreamOpen,
+		content: []byte(call),
+	})
 	if err != nil {
+		e.streams.remove(s.id)
+		s.cancel()
 		return nil, err
 	}
-	rsp := <-channel
-	e.callLocker.Lock()
-	o, ok := e.calls[req.id]
-	if ok {
-		close(o.channel)
-		delete(e.calls, req.id)
+
+	go func() {
+		select {
+		case <-s.done:
+		case <-s.ctx.Done():
+			_ = conn.send(&sendObject{
+				id:   s.id,
+				call: callCancel,
+			})
+		}
+		e.streams.remove(s.id)
+		s.cancel()
+	}()
+	return s, nil
+}
+
+// ServerStream opens a stream, sends data as its only request and closes the
+// sending side. The replies are read with Recv until it returns io.EOF.
+func (e *Entity) ServerStream(ctx context.Context, call string, data []byte) (*Stream, error) {
+	s, err := e.OpenStream(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Send(data); err != nil {
+		s.cancel()
+		return nil, err
+	}
+	if err = s.CloseSend(); err != nil {
+		s.cancel()
+		return nil, err
 	}
-	e.callLocker.Unlock()
-	return rsp.content, rsp.err
+	return s, nil
 }
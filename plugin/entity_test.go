@@ -0,0 +1,369 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/delichik/daf/logger"
+)
+
+// envTestParasite makes the test binary run as the test parasite it names
+// instead of running the tests.
+const envTestParasite = "PLUGIN_TEST_PARASITE"
+
+// envTestDir names the directory the test parasites share with the tests.
+const envTestDir = "PLUGIN_TEST_DIR"
+
+// testParasites registers the handlers of each test parasite and sets the
+// options it runs with.
+var testParasites = map[string]func(options *Options){}
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(envTestParasite); name != "" {
+		options := &Options{
+			Name:               name,
+			Version:            "1.2.3",
+			HostName:           "host",
+			HostMinimalVersion: "1.0.0",
+		}
+		// the test parasite only serves the handlers it registers
+		defaultRegistry = newRegistry()
+		testParasites[name](options)
+		RunParasite(options)
+		os.Exit(0)
+	}
+	// the logs of the host are kept for the tests checking them
+	logger.InitDefaultManual(&logger.Config{Level: "debug", Format: "json", LogDriver: hostLog})
+	os.Exit(m.Run())
+}
+
+// handlerFunc is a Parasite handling its calls with a function.
+type handlerFunc func(data []byte) ([]byte, error)
+
+func (f handlerFunc) Init() error {
+	return nil
+}
+
+func (f handlerFunc) UnInit() {}
+
+func (f handlerFunc) Handle(data []byte) ([]byte, error) {
+	return f(data)
+}
+
+// recordEvent appends event to the events of the test parasites.
+func recordEvent(event string) {
+	f, err := os.OpenFile(filepath.Join(os.Getenv(envTestDir), "events"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	_, _ = f.WriteString(event + "\n")
+}
+
+// countEvents counts the times the test parasites sharing dir recorded event.
+func countEvents(dir string, event string) int {
+	content, _ := os.ReadFile(filepath.Join(dir, "events"))
+	n := 0
+	for _, recorded := range strings.Fields(string(content)) {
+		if recorded == event {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// loadTestParasites loads the named test parasites, each one runs the test
+// binary through a script. It returns the directory they share with the test.
+func loadTestParasites(t *testing.T, h *Host, names ...string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the test parasites are run by shell scripts")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	shared := t.TempDir()
+	for _, name := range names {
+		// the exit of a parasite built with -race is not delayed
+		script := fmt.Sprintf("#!/bin/sh\nGORACE=atexit_sleep_ms=0 %s=%s %s='%s' exec '%s' \"$@\"\n",
+			envTestParasite, name, envTestDir, shared, executable)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, name := range h.Parasites() {
+			if e, ok := h.Parasite(name); ok {
+				_ = e.Stop()
+			}
+		}
+	})
+	if err := h.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	return shared
+}
+
+func init() {
+	testParasites["output"] = func(options *Options) {
+		fmt.Println("printed to stdout")
+		fmt.Fprintln(os.Stderr, "printed to stderr")
+		RegisterHandler("echo", handlerFunc(func(data []byte) ([]byte, error) {
+			return data, nil
+		}))
+	}
+}
+
+// logBuffer collects what the logger writes.
+type logBuffer struct {
+	buffer bytes.Buffer
+	locker sync.Mutex
+}
+
+func (b *logBuffer) Write(data []byte) (int, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.buffer.Write(data)
+}
+
+func (b *logBuffer) Sync() error {
+	return nil
+}
+
+// hostLog collects the logs of the host.
+var hostLog = &logBuffer{}
+
+// entries decodes the entries written so far.
+func (b *logBuffer) entries() []map[string]any {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	var entries []map[string]any
+	for _, line := range strings.Split(b.buffer.String(), "\n") {
+		entry := map[string]any{}
+		if json.Unmarshal([]byte(line), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestEntity_handshake(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	loadTestParasites(t, h, "b")
+	e, ok := h.Parasite("b")
+	if !ok {
+		t.Fatal("the parasite should be loaded")
+	}
+	if e.Name() != "b" || e.Version() != "1.2.3" {
+		t.Errorf("unexpected name and version %s %s", e.Name(), e.Version())
+	}
+	if calls := strings.Join(e.Calls(), ","); calls != "bye,fail,shared" {
+		t.Errorf("the calls should be advertised sorted, got %s", calls)
+	}
+
+	refused := errors.New("refused")
+	h = NewHostWithOptions(&HostOptions{
+		Name:    "host",
+		Version: "1.0.0",
+		CheckParasite: func(info *HandshakeInfo) error {
+			if info.Name != "b" || len(info.Calls) != 3 {
+				t.Errorf("unexpected handshake %+v", info)
+			}
+			return refused
+		},
+	})
+	loadTestParasites(t, h, "b")
+	if _, ok := h.Parasite("b"); ok {
+		t.Error("the refused parasite should not be loaded")
+	}
+}
+
+func init() {
+	testParasites["draining"] = func(options *Options) {
+		RegisterHandler("wait", draining{})
+		RegisterHandler("echo", handlerFunc(func(data []byte) ([]byte, error) {
+			return data, nil
+		}))
+	}
+}
+
+// draining holds its calls until the test creates the release file and
+// records its UnInit.
+type draining struct{}
+
+func (draining) Init() error {
+	return nil
+}
+
+func (draining) UnInit() {
+	recordEvent("uninit")
+}
+
+func (draining) Handle(data []byte) ([]byte, error) {
+	recordEvent("handling")
+	release := filepath.Join(os.Getenv(envTestDir), "release")
+	for {
+		if _, err := os.Stat(release); err == nil {
+			return data, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHost_Shutdown(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	dir := loadTestParasites(t, h, "draining")
+	e, ok := h.Parasite("draining")
+	if !ok {
+		t.Fatal("the parasite should be loaded")
+	}
+	conn := e.currentConn()
+
+	type result struct {
+		rsp []byte
+		err error
+	}
+	inflight := make(chan *result, 1)
+	go func() {
+		rsp, err := e.CallWithResponse("wait", []byte("data"))
+		inflight <- &result{rsp: rsp, err: err}
+	}()
+	waitFor(t, "the call to be handled", func() bool {
+		return countEvents(dir, "handling") == 1
+	})
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- h.Shutdown(context.Background())
+	}()
+	waitFor(t, "the parasite to be stopping", e.stopping.Load)
+	if _, err := e.CallWithResponse("echo", nil); !errors.Is(err, ErrStopped) {
+		t.Errorf("the host should not send new calls, got %v", err)
+	}
+	// a call the parasite reads once it is asked to shut down is refused
+	waitFor(t, "the parasite to refuse the calls", func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := roundTrip(ctx, conn, &e.pending, "echo", nil)
+		return errors.Is(err, ErrShuttingDown)
+	})
+	if n := countEvents(dir, "uninit"); n != 0 {
+		t.Error("UnInit should wait for the calls being handled")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "release"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-inflight; r.err != nil || string(r.rsp) != "data" {
+		t.Errorf("the call being handled should be drained, got %q %v", r.rsp, r.err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if n := countEvents(dir, "uninit"); n != 1 {
+		t.Errorf("UnInit should run once, got %d", n)
+	}
+	waitFor(t, "the parasite to be removed", func() bool {
+		_, ok := h.Parasite("draining")
+		return !ok
+	})
+}
+
+func TestEntity_Shutdown_signals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the parasite is a shell script handling SIGTERM")
+	}
+	tests := []struct {
+		name   string
+		onTerm string
+		killed bool
+	}{
+		{name: "terminated", onTerm: "exit 0"},
+		{name: "killed", onTerm: ":", killed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signals := filepath.Join(t.TempDir(), "signals")
+			// the parasite never answers, it records SIGTERM
+			cmd := exec.Command("sh", "-c",
+				`trap 'echo TERM >> "$1"; `+test.onTerm+`' TERM; while :; do sleep 0.01; done`, "sh", signals)
+			proc := &process{
+				runner: &cmdRunner{cmd: cmd},
+				exited: make(chan struct{}),
+			}
+			if err := proc.startWithStdio(cmd); err != nil {
+				t.Fatal(err)
+			}
+			e := newEntity("silent", nil, NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"}))
+			e.proc = proc
+			go proc.wait()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := e.Shutdown(ctx)
+			if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), test.name) {
+				t.Errorf("unexpected error %v", err)
+			}
+			select {
+			case <-proc.exited:
+			default:
+				t.Fatal("the parasite should have exited")
+			}
+			content, _ := os.ReadFile(signals)
+			if string(content) != "TERM\n" {
+				t.Errorf("the parasite should get SIGTERM first, got %q", content)
+			}
+			if killed := cmd.ProcessState.ExitCode() == -1; killed != test.killed {
+				t.Errorf("unexpected state %s", cmd.ProcessState)
+			}
+		})
+	}
+}
+
+func TestEntity_forwardOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the RPC frames go through the standard input and output on windows")
+	}
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	loadTestParasites(t, h, "output")
+
+	if rsp, err := h.CallParasite("output", "echo", []byte("data")); err != nil || string(rsp) != "data" {
+		t.Errorf("the RPC frames should not be mixed with the output, got %q %v", rsp, err)
+	}
+	forwarded := func(stream string, level string) bool {
+		for _, entry := range hostLog.entries() {
+			if entry["msg"] == "[parasite] printed to "+stream {
+				return entry["parasite_name"] == "output" && entry["level"] == level && entry["stream"] == stream
+			}
+		}
+		return false
+	}
+	waitFor(t, "the output to be forwarded", func() bool {
+		return forwarded("stdout", "info") && forwarded("stderr", "warn")
+	})
+}
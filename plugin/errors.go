@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrParasiteNotFound = errors.New("parasite not found")
+	ErrNoRoute          = errors.New("no parasite serves the call")
+	ErrParasiteRefused  = errors.New("parasite refused")
+	ErrParasiteDisabled = errors.New("parasite disabled")
+	ErrHandshake        = errors.New("parasite handshake failed")
+	ErrParasiteInit     = errors.New("parasite init failed")
+	ErrHostRefused      = errors.New("host refused by the parasite")
+	ErrTimeout          = errors.New("call timed out")
+	ErrCanceled         = errors.New("call canceled")
+	ErrStopped          = errors.New("parasite stopped")
+	ErrParasiteExited   = errors.New("parasite exited")
+	ErrUnknownCall      = errors.New("unknown call")
+	ErrHandlerPanic     = errors.New("handler panicked")
+	ErrShuttingDown     = errors.New("parasite is shutting down")
+	ErrHostClosed       = errors.New("host closed the pipe")
+	ErrParasiteBusy     = errors.New("parasite is busy")
+)
+
+type ErrorCode uint32
+
+const (
+	// CodeHandlerFailed is used for any error a handler returns that is not
+	// a *RemoteError itself.
+	CodeHandlerFailed ErrorCode = iota + 1
+	CodeUnknownCall
+	CodeHandlerPanic
+	CodeTimeout
+	CodeCanceled
+	CodeShuttingDown
+	CodeHostRefused
+	CodeBusy
+)
+
+// codeErrors maps the well-known codes to the errors a *RemoteError matches
+// with errors.Is.
+var codeErrors = map[ErrorCode]error{
+	CodeUnknownCall:  ErrUnknownCall,
+	CodeHandlerPanic: ErrHandlerPanic,
+	CodeTimeout:      ErrTimeout,
+	CodeCanceled:     ErrCanceled,
+	CodeShuttingDown: ErrShuttingDown,
+	CodeHostRefused:  ErrHostRefused,
+	CodeBusy:         ErrParasiteBusy,
+}
+
+// RemoteError is an error returned by the other side of the pipe, either a
+// parasite handler or the Executor of the host. Handlers may return a
+// *RemoteError to choose the code and details the caller gets.
+type RemoteError struct {
+	Code    ErrorCode         `msgpack:"code"`
+	Message string            `msgpack:"message"`
+	Details map[string]string `msgpack:"details,omitempty"`
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error %d: %s", e.Code, e.Message)
+}
+
+// Is reports whether target is the error of a well-known code or a
+// *RemoteError with the same code.
+func (e *RemoteError) Is(target error) bool {
+	if t, ok := target.(*RemoteError); ok {
+		return t.Code == e.Code
+	}
+	codeErr, ok := codeErrors[e.Code]
+	return ok && codeErr == target
+}
+
+func toRemoteError(err error) *RemoteError {
+	if err == nil {
+		return nil
+	}
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		return remoteErr
+	}
+	code := CodeHandlerFailed
+	switch {
+	case errors.Is(err, ErrUnknownCall):
+		code = CodeUnknownCall
+	case errors.Is(err, ErrHandlerPanic):
+		code = CodeHandlerPanic
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		code = CodeTimeout
+	case errors.Is(err, ErrCanceled), errors.Is(err, context.Canceled):
+		code = CodeCanceled
+	case errors.Is(err, ErrShuttingDown):
+		code = CodeShuttingDown
+	case errors.Is(err, ErrHostRefused):
+		code = CodeHostRefused
+	case errors.Is(err, ErrParasiteBusy):
+		code = CodeBusy
+	}
+	return &RemoteError{
+		Code:    code,
+		Message: err.Error(),
+	}
+}
@@ -0,0 +1,29 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRemoteError(t *testing.T) {
+	err := error(toRemoteError(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("%v should be ErrTimeout", err)
+	}
+	if errors.Is(err, ErrCanceled) {
+		t.Errorf("%v should not be ErrCanceled", err)
+	}
+	if !errors.Is(err, &RemoteError{Code: CodeTimeout}) {
+		t.Errorf("%v should match its code", err)
+	}
+
+	custom := &RemoteError{Code: 100, Message: "custom", Details: map[string]string{"k": "v"}}
+	if toRemoteError(fmt.Errorf("wrapped: %w", custom)) != custom {
+		t.Errorf("a *RemoteError should be sent as is")
+	}
+	if toRemoteError(errors.New("failed")).Code != CodeHandlerFailed {
+		t.Errorf("other errors should use CodeHandlerFailed")
+	}
+}
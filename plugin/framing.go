@@ -0,0 +1,340 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	framingText   = "text"
+	framingBinary = "binary"
+)
+
+// supportedFramings is ordered by preference.
+var supportedFramings = []string{framingBinary, framingText}
+
+type framing interface {
+	write(writer io.Writer, r *sendObject) error
+	read(reader *bufio.Reader) (*sendObject, error)
+}
+
+func framingByName(name string) (framing, bool) {
+	switch name {
+	case framingText:
+		return textFraming{}, true
+	case framingBinary:
+		return binaryFraming{}, true
+	}
+	return nil, false
+}
+
+// pickFraming returns the first supported framing offered by the host, the
+// text framing if there is none.
+func pickFraming(offered []string) string {
+	for _, name := range offered {
+		if _, ok := framingByName(name); ok {
+			return name
+		}
+	}
+	return framingText
+}
+
+const (
+	orderPrefix = "\n\n__mfk_parasite_order__"
+	splitter    = "|"
+)
+
+// orderMarker is what read finds at the start of a line of a frame.
+var orderMarker = strings.TrimLeft(orderPrefix, "\n")
+
+var safeCallCache sync.Map
+
+// textFraming writes each frame as one line of base64 encoded fields, it is
+// what every parasite understands.
+type textFraming struct{}
+
+func (textFraming) write(writer io.Writer, r *sendObject) error {
+	safeData := base64.StdEncoding.EncodeToString(r.content)
+	safeCall := ""
+	t, loaded := safeCallCache.Load(r.call)
+	if loaded {
+		safeCall = t.(string)
+	} else {
+		safeCall = base64.StdEncoding.EncodeToString([]byte(r.call))
+		safeCallCache.Store(r.call, safeCall)
+	}
+
+	frame := orderPrefix + strconv.FormatUint(r.id, 10) + splitter + safeCall + splitter + safeData
+	if r.remoteErr != nil || len(r.metadata) > 0 {
+		// the error field is left empty for a frame with metadata only
+		safeErr := ""
+		if r.remoteErr != nil {
+			errData, err := msgpack.Marshal(r.remoteErr)
+			if err != nil {
+				return err
+			}
+			safeErr = base64.StdEncoding.EncodeToString(errData)
+		}
+		frame += splitter + safeErr
+	}
+	if len(r.metadata) > 0 {
+		mdData, err := msgpack.Marshal(map[string]string(r.metadata))
+		if err != nil {
+			return err
+		}
+		frame += splitter + base64.StdEncoding.EncodeToString(mdData)
+	}
+
+	// The whole frame is written on a single line so that it can not be
+	// interleaved with other output, the leading line breaks of orderPrefix
+	// make sure it starts on a fresh line.
+	_, err := fmt.Fprintln(writer, frame)
+	return err
+}
+
+func (textFraming) read(reader *bufio.Reader) (*sendObject, error) {
+	line := ""
+	for {
+		p, prefix, err := reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line += string(p)
+		if prefix {
+			continue
+		}
+		t := line
+		line = ""
+		if strings.HasPrefix(t, orderMarker) {
+			parts := strings.Split(t[len(orderMarker):], splitter)
+			if len(parts) < 3 || len(parts) > 5 {
+				continue
+			}
+
+			rsp := &sendObject{}
+			rsp.id, err = strconv.ParseUint(parts[0], 10, 0)
+			if err != nil {
+				rsp.err = fmt.Errorf("decode id failed: %w", err)
+				return rsp, nil
+			}
+
+			call, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				rsp.err = fmt.Errorf("decode call failed: %w", err)
+				return rsp, nil
+			}
+			rsp.call = string(call)
+			rsp.content, err = base64.StdEncoding.DecodeString(parts[2])
+			if err != nil {
+				rsp.err = fmt.Errorf("decode content failed: %w", err)
+				return rsp, nil
+			}
+			if len(parts) >= 4 && parts[3] != "" {
+				errData, err := base64.StdEncoding.DecodeString(parts[3])
+				if err != nil {
+					rsp.err = fmt.Errorf("decode error failed: %w", err)
+					return rsp, nil
+				}
+				rsp.remoteErr = &RemoteError{}
+				if err = msgpack.Unmarshal(errData, rsp.remoteErr); err != nil {
+					rsp.err = fmt.Errorf("decode error failed: %w", err)
+					return rsp, nil
+				}
+			}
+			if len(parts) == 5 {
+				rsp.metadata, err = decodeMetadata(parts[4])
+				if err != nil {
+					rsp.err = err
+					return rsp, nil
+				}
+			}
+			return rsp, nil
+		}
+	}
+}
+
+// The binary framing writes each frame as
+//
+//	magic       4 bytes
+//	version     1 byte
+//	flags       1 byte
+//	id          8 bytes
+//	call        2 bytes length + call
+//	content     4 bytes length + content
+//	error       4 bytes length + msgpack of RemoteError, if flagError is set
+//	metadata    4 bytes length + msgpack of Metadata, if flagMetadata is set
+//
+// with all integers in big endian. The reader skips anything before the
+// magic, like text a parasite printed to its stdout.
+var binaryMagic = [4]byte{'M', 'F', 'K', 'P'}
+
+const (
+	binaryVersion = 1
+
+	flagError    = 1 << 0
+	flagMetadata = 1 << 1
+
+	binaryHeaderSize = len(binaryMagic) + 1 + 1 + 8 + 2
+	maxFrameContent  = 256 << 20
+)
+
+var errFrameTooLarge = errors.New("frame too large")
+
+type binaryFraming struct{}
+
+func (binaryFraming) write(writer io.Writer, r *sendObject) error {
+	var errData []byte
+	flags := byte(0)
+	if r.remoteErr != nil {
+		var err error
+		errData, err = msgpack.Marshal(r.remoteErr)
+		if err != nil {
+			return err
+		}
+		flags |= flagError
+	}
+	var mdData []byte
+	if len(r.metadata) > 0 {
+		var err error
+		mdData, err = msgpack.Marshal(map[string]string(r.metadata))
+		if err != nil {
+			return err
+		}
+		flags |= flagMetadata
+	}
+	if len(r.call) > 0xffff {
+		return fmt.Errorf("call name too long: %d", len(r.call))
+	}
+	// nothing is written, the frames sent after it still get through
+	for _, field := range [][]byte{r.content, errData, mdData} {
+		if len(field) > maxFrameContent {
+			return fmt.Errorf("%w: %d bytes", errFrameTooLarge, len(field))
+		}
+	}
+
+	size := binaryHeaderSize + len(r.call) + 4 + len(r.content)
+	if flags&flagError != 0 {
+		size += 4 + len(errData)
+	}
+	if flags&flagMetadata != 0 {
+		size += 4 + len(mdData)
+	}
+	frame := make([]byte, 0, size)
+	frame = append(frame, binaryMagic[:]...)
+	frame = append(frame, binaryVersion, flags)
+	frame = binary.BigEndian.AppendUint64(frame, r.id)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(r.call)))
+	frame = append(frame, r.call...)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(r.content)))
+	frame = append(frame, r.content...)
+	if flags&flagError != 0 {
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(errData)))
+		frame = append(frame, errData...)
+	}
+	if flags&flagMetadata != 0 {
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(mdData)))
+		frame = append(frame, mdData...)
+	}
+	_, err := writer.Write(frame)
+	return err
+}
+
+func (binaryFraming) read(reader *bufio.Reader) (*sendObject, error) {
+	matched := 0
+	for matched < len(binaryMagic) {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case b == binaryMagic[matched]:
+			matched++
+		case b == binaryMagic[0]:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+
+	header := make([]byte, binaryHeaderSize-len(binaryMagic))
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	version, flags := header[0], header[1]
+	rsp := &sendObject{
+		id: binary.BigEndian.Uint64(header[2:10]),
+	}
+	call := make([]byte, binary.BigEndian.Uint16(header[10:12]))
+	if _, err := io.ReadFull(reader, call); err != nil {
+		return nil, err
+	}
+	rsp.call = string(call)
+
+	var err error
+	rsp.content, err = readBinaryField(reader)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagError != 0 {
+		errData, err := readBinaryField(reader)
+		if err != nil {
+			return nil, err
+		}
+		rsp.remoteErr = &RemoteError{}
+		if err = msgpack.Unmarshal(errData, rsp.remoteErr); err != nil {
+			rsp.err = fmt.Errorf("decode error failed: %w", err)
+		}
+	}
+	if flags&flagMetadata != 0 {
+		mdData, err := readBinaryField(reader)
+		if err != nil {
+			return nil, err
+		}
+		md := map[string]string{}
+		if err = msgpack.Unmarshal(mdData, &md); err != nil {
+			rsp.err = fmt.Errorf("decode metadata failed: %w", err)
+		}
+		rsp.metadata = md
+	}
+	if version != binaryVersion {
+		rsp.err = fmt.Errorf("unsupported frame version %d", version)
+	}
+	return rsp, nil
+}
+
+func decodeMetadata(field string) (Metadata, error) {
+	mdData, err := base64.StdEncoding.DecodeString(field)
+	if err != nil {
+		return nil, fmt.Errorf("decode metadata failed: %w", err)
+	}
+	md := map[string]string{}
+	if err = msgpack.Unmarshal(mdData, &md); err != nil {
+		return nil, fmt.Errorf("decode metadata failed: %w", err)
+	}
+	return md, nil
+}
+
+func readBinaryField(reader *bufio.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(reader, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameContent {
+		return nil, fmt.Errorf("%w: %d bytes", errFrameTooLarge, n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestFraming(t *testing.T) {
+	for _, name := range supportedFramings {
+		t.Run(name, func(t *testing.T) {
+			f, _ := framingByName(name)
+			buf := &bytes.Buffer{}
+			buf.WriteString("Parasite test is starting...\n")
+			err := f.write(buf, &sendObject{
+				id:      1,
+				call:    "test",
+				content: []byte("hello\nworld"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.WriteString("MFK printed between frames\n")
+			err = f.write(buf, &sendObject{
+				id:        2,
+				call:      "test" + callReply,
+				remoteErr: toRemoteError(fmt.Errorf("%w: test", ErrUnknownCall)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.write(buf, &sendObject{
+				id:       3,
+				call:     "test",
+				metadata: Metadata{"trace_id": "abc"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reader := bufio.NewReader(buf)
+			req, err := f.read(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.id != 1 || req.call != "test" || string(req.content) != "hello\nworld" || req.remoteErr != nil {
+				t.Errorf("unexpected frame %+v", req)
+			}
+			rsp, err := f.read(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rsp.id != 2 || rsp.call != "test"+callReply || rsp.remoteErr == nil {
+				t.Fatalf("unexpected frame %+v", rsp)
+			}
+			if !errors.Is(rsp.remoteErr, ErrUnknownCall) {
+				t.Errorf("%v should be ErrUnknownCall", rsp.remoteErr)
+			}
+			req, err = f.read(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.id != 3 || req.remoteErr != nil || req.err != nil || req.metadata["trace_id"] != "abc" {
+				t.Errorf("unexpected frame %+v", req)
+			}
+			if _, err = f.read(reader); err != io.EOF {
+				t.Errorf("should be EOF, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBinaryFraming_tooLarge(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := binaryFraming{}.write(buf, &sendObject{
+		id:      1,
+		call:    "test",
+		content: make([]byte, maxFrameContent+1),
+	})
+	if !errors.Is(err, errFrameTooLarge) {
+		t.Fatalf("the frame should be refused, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("nothing should be written, got %d bytes", buf.Len())
+	}
+
+	err = binaryFraming{}.write(buf, &sendObject{id: 2, call: "test", content: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := binaryFraming{}.read(bufio.NewReader(buf))
+	if err != nil || req.id != 2 || string(req.content) != "hello" {
+		t.Errorf("the next frame should get through, got %+v %v", req, err)
+	}
+}
+
+func TestPickFraming(t *testing.T) {
+	if pickFraming(nil) != framingText {
+		t.Errorf("should fall back to the text framing")
+	}
+	if pickFraming([]string{"unknown", framingBinary, framingText}) != framingBinary {
+		t.Errorf("should pick the first supported framing")
+	}
+}
+
+func benchmarkFraming(b *testing.B, name string, size int) {
+	f, _ := framingByName(name)
+	r := &sendObject{
+		id:      1,
+		call:    "benchmark",
+		content: bytes.Repeat([]byte{0xa5}, size),
+	}
+	buf := &bytes.Buffer{}
+	reader := bufio.NewReader(buf)
+
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := f.write(buf, r); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := f.read(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTextFraming64(b *testing.B)    { benchmarkFraming(b, framingText, 64) }
+func BenchmarkBinaryFraming64(b *testing.B)  { benchmarkFraming(b, framingBinary, 64) }
+func BenchmarkTextFraming64K(b *testing.B)   { benchmarkFraming(b, framingText, 64<<10) }
+func BenchmarkBinaryFraming64K(b *testing.B) { benchmarkFraming(b, framingBinary, 64<<10) }
@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/delichik/daf/logger"
+	"go.uber.org/zap"
+)
+
+const defaultUnhealthyThreshold = 3
+
+// Ping checks that the parasite answers, without going through its handlers.
+func (e *Entity) Ping(ctx context.Context) error {
+	conn := e.currentConn()
+	if conn == nil {
+		return ErrParasiteExited
+	}
+	_, err := roundTrip(ctx, conn, &e.pending, callPing, nil)
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		// parasites built before the ping was introduced do not know it, but
+		// they did answer
+		return nil
+	}
+	return err
+}
+
+// Healthy reports whether the parasite answers the health checks. It is
+// always true when the host does not check the health of its parasites.
+func (e *Entity) Healthy() bool {
+	return !e.unhealthy.Load()
+}
+
+// checkHealth pings the parasite every HealthCheckInterval until it is
+// stopped, and marks it unhealthy once UnhealthyThreshold pings in a row
+// fail.
+func (e *Entity) checkHealth() {
+	options := e.host.options
+	if options.HealthCheckInterval <= 0 {
+		return
+	}
+	timeout := options.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = options.HealthCheckInterval
+	}
+	threshold := options.UnhealthyThreshold
+	if threshold <= 0 {
+		threshold = defaultUnhealthyThreshold
+	}
+
+	ticker := time.NewTicker(options.HealthCheckInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if e.stopping.Load() {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(e.ctx, timeout)
+		err := e.Ping(ctx)
+		cancel()
+		if err == nil {
+			failures = 0
+			if e.unhealthy.CompareAndSwap(true, false) {
+				logger.Info("parasite is healthy again", zap.String("parasite_name", e.name))
+				e.host.emit(&Event{Type: EventHealthy, Parasite: e.name})
+			}
+			continue
+		}
+		failures++
+		if failures >= threshold && e.unhealthy.CompareAndSwap(false, true) {
+			logger.Warn("parasite is unhealthy", zap.String("parasite_name", e.name), zap.Error(err))
+			e.host.emit(&Event{Type: EventUnhealthy, Parasite: e.name})
+		}
+	}
+}
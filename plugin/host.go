@@ -1,11 +1,18 @@
 package plugin
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/vmihailenco/msgpack"
 	"go.uber.org/zap"
@@ -13,121 +20,516 @@ import (
 	"github.com/delichik/daf/logger"
 )
 
+const defaultCallTimeout = 5 * time.Second
+
+type HostOptions struct {
+	Name     string
+	Version  string
+	Executor Executor
+	// DefaultTimeout bounds the calls made without a context, 5 seconds if
+	// it is not set.
+	DefaultTimeout time.Duration
+	// StdioTransport makes parasites exchange frames over their standard
+	// input and output instead of a dedicated pipe pair, as parasites built
+	// before the pipes were introduced do. It is always the case on Windows.
+	StdioTransport bool
+	// RestartPolicy is used for parasites that exit on their own,
+	// DefaultRestartPolicy if it is not set.
+	RestartPolicy *RestartPolicy
+	// Codecs are the names of the codecs offered to parasites by preference,
+	// every registered codec if it is not set.
+	Codecs []string
+	// CheckParasite is called with the handshake a parasite sends back after
+	// it starts. Returning an error refuses the parasite and stops it.
+	CheckParasite func(info *HandshakeInfo) error
+	// MinimalParasiteVersion refuses parasites older than this version.
+	MinimalParasiteVersion string
+
+	// Secret is a key shared with the parasites. When it or TrustedKeys is
+	// set, parasites must prove they hold the secret or one of the keys by
+	// answering a nonce the host sends with the handshake.
+	Secret      []byte
+	TrustedKeys []ed25519.PublicKey
+	// ChecksumFile is a manifest in the format of sha256sum that every
+	// parasite executable must be listed in with a matching checksum.
+	ChecksumFile string
+	// SignatureKeys requires every parasite executable to come with a
+	// detached Ed25519 signature of its content by one of the keys, in a file
+	// with the ".sig" suffix next to it. On Linux the parasite is executed
+	// from the file that was checked, elsewhere the executable is opened
+	// again by path and may be replaced in between.
+	SignatureKeys []ed25519.PublicKey
+
+	// Parasites are the names or glob patterns of the parasites Load and
+	// LoadOne load, every one if it is not set.
+	Parasites []string
+	// RequireManifest makes Load ignore executables without a manifest.
+	RequireManifest bool
+	// WatchInterval is how often Watch scans the parasite directory, 2
+	// seconds if it is not set.
+	WatchInterval time.Duration
+	// AttachReplaces lets a parasite connecting with Attach or Serve replace
+	// a parasite with the same name the host runs itself, which is drained.
+	// Otherwise it is refused, and only replaces a parasite that connected
+	// before it.
+	AttachReplaces bool
+
+	// MetricsSink receives the measurements of the parasites on top of the
+	// ones kept for Entity.Metrics.
+	MetricsSink MetricsSink
+
+	// Sandbox restricts the processes of the parasites, Sandboxes the ones of
+	// the named parasites instead.
+	Sandbox   *Sandbox
+	Sandboxes map[string]*Sandbox
+
+	// LogSink receives the log entries of the parasites, which are logged with
+	// the default logger if it is not set.
+	LogSink LogSink
+	// LogLevel is the minimum level of the entries forwarded, "debug" if it is
+	// not set, and LogLevels the one of the named parasites.
+	LogLevel  string
+	LogLevels map[string]string
+	// LogRateLimit caps the entries forwarded per second and parasite, the
+	// others are dropped and counted. There is no limit if it is not set.
+	LogRateLimit int
+	// HealthCheckInterval is how often parasites are pinged, they are not if
+	// it is not set. A parasite is marked unhealthy once UnhealthyThreshold
+	// pings in a row, 3 if it is not set, get no answer within
+	// HealthCheckTimeout, HealthCheckInterval if it is not set.
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	UnhealthyThreshold  int
+
+	// OutgoingInterceptors run around the calls sent to the parasites, and
+	// IncomingInterceptors around the calls of the parasites handled by the
+	// Executor. The first one is the outermost.
+	OutgoingInterceptors []Interceptor
+	IncomingInterceptors []Interceptor
+}
+
 type Host struct {
-	parasites map[string]*Entity
-	name      string
-	version   string
-	e         Executor
+	parasites       map[string]*Entity
+	routes          map[string]string
+	parasitesLocker sync.RWMutex
+	name            string
+	version         string
+	e               Executor
+	options         *HostOptions
+	subscribers     subscribers
+	outgoing        Interceptor
+	incoming        Interceptor
 }
 
 func NewHost(name string, version string, e Executor) *Host {
-	return &Host{
+	return NewHostWithOptions(&HostOptions{
+		Name:     name,
+		Version:  version,
+		Executor: e,
+	})
+}
+
+func NewHostWithOptions(options *HostOptions) *Host {
+	h := &Host{
 		parasites: make(map[string]*Entity),
-		name:      name,
-		version:   version,
-		e:         e,
+		routes:    make(map[string]string),
+		name:      options.Name,
+		version:   options.Version,
+		e:         options.Executor,
+		options:   options,
 	}
+	if len(options.OutgoingInterceptors) > 0 {
+		h.outgoing = ChainInterceptors(options.OutgoingInterceptors...)
+	}
+	if len(options.IncomingInterceptors) > 0 {
+		h.incoming = ChainInterceptors(options.IncomingInterceptors...)
+	}
+	return h
 }
 
-func (h *Host) Load(parasitePath string) error {
+func (h *Host) defaultTimeout() time.Duration {
+	if h.options.DefaultTimeout > 0 {
+		return h.options.DefaultTimeout
+	}
+	return defaultCallTimeout
+}
+
+func (h *Host) codecs() []string {
+	if len(h.options.Codecs) > 0 {
+		return h.options.Codecs
+	}
+	return registeredCodecNames()
+}
+
+// handshake encodes the handshake passed to parasites with -h.
+func (h *Host) handshake(nonce []byte) string {
 	handshake, err := msgpack.Marshal(&HandshakeInfo{
-		Name:    h.name,
-		Version: h.version,
+		Name:     h.name,
+		Version:  h.version,
+		Framings: supportedFramings,
+		Codecs:   h.codecs(),
+		Nonce:    nonce,
+		Metadata: true,
 	})
 	if err != nil {
 		panic(err)
 	}
+	return base64.StdEncoding.EncodeToString(handshake)
+}
+
+// Load loads the parasites found in parasitePath, see Manifest. A manifest
+// that can not be read or a parasite that fails to start is logged and does
+// not stop the others.
+func (h *Host) Load(parasitePath string) error {
+	manifests, _, err := h.readManifests(parasitePath)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range manifests {
+		err = h.loadManifest(m)
+		if errors.Is(err, ErrParasiteDisabled) {
+			logger.Info("skip parasite", zap.String("name", m.Name), zap.Error(err))
+		} else if err != nil {
+			logger.Error("fail to load parasite", zap.String("name", m.Name), zap.Error(err))
+		}
+	}
 
-	handshakeStr := base64.StdEncoding.EncodeToString(handshake)
+	return nil
+}
 
-	entries, err := os.ReadDir(parasitePath)
+// LoadOne loads a single parasite from a manifest, a directory holding a
+// manifest or an executable.
+func (h *Host) LoadOne(path string) error {
+	info, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
+	var m *Manifest
+	switch {
+	case info.IsDir():
+		manifestPath, ok := findManifest(path)
+		if !ok {
+			return fmt.Errorf("no manifest in %s", path)
+		}
+		m, err = ReadManifest(manifestPath)
+	case isManifest(info.Name()):
+		m, err = ReadManifest(path)
+	default:
+		m = &Manifest{
+			Name:       strings.TrimSuffix(info.Name(), ".exe"),
+			Executable: info.Name(),
+			dir:        filepath.Dir(path),
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return h.loadManifest(m)
+}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+func (h *Host) loadManifest(m *Manifest) error {
+	if !m.enabled() {
+		return fmt.Errorf("%w: %s is disabled by its manifest", ErrParasiteDisabled, m.Name)
+	}
+	if !h.selected(m.Name) {
+		return fmt.Errorf("%w: %s is not selected", ErrParasiteDisabled, m.Name)
+	}
+	if m.HostVersion != "" {
+		constraint, err := ParseVersionConstraint(m.HostVersion)
+		if err != nil {
+			return err
+		}
+		version, err := ParseVersion(h.version)
+		if err != nil {
+			return err
+		}
+		if !constraint.Check(version) {
+			return fmt.Errorf("%w: host version %s does not satisfy %s", ErrParasiteRefused, h.version, constraint)
 		}
-		name := strings.TrimSuffix(entry.Name(), ".exe")
-		logger.Info("load parasite", zap.String("name", name))
-		cmd := exec.Command(parasitePath+"/"+entry.Name(), "-h", handshakeStr)
-		h.parasites[name] = newEntity(name, cmd, h)
-		err = h.parasites[name].Start()
+	}
+
+	logger.Info("load parasite", zap.String("name", m.Name))
+	path := m.executablePath()
+	newCmd := func(handshake string) (*exec.Cmd, error) {
+		// the executable is checked before every run, it may have been
+		// replaced since the last one
+		verified, err := h.options.verifyBinary(path)
 		if err != nil {
-			logger.Error("fail to load parasite", zap.String("name", entry.Name()), zap.Error(err))
+			return nil, fmt.Errorf("%w: %w", ErrParasiteRefused, err)
 		}
+		cmd := exec.Command(path, append([]string{"-h", handshake}, m.Args...)...)
+		if verified != nil {
+			execVerified(cmd, verified)
+		}
+		cmd.Dir = m.workingDir()
+		cmd.Env = h.sandbox(m.Name).environ()
+		if len(m.Env) > 0 {
+			if cmd.Env == nil {
+				cmd.Env = os.Environ()
+			}
+			for k, v := range m.Env {
+				cmd.Env = append(cmd.Env, k+"="+v)
+			}
+		}
+		return cmd, nil
 	}
+	return newEntity(m.Name, cmdSpawner(newCmd), h).Start()
+}
 
+// checkParasite checks the handshake a parasite sent back, including its
+// answer to nonce, against the options of the host.
+func (h *Host) checkParasite(info *HandshakeInfo, nonce []byte) error {
+	if h.options.authenticates() {
+		if err := h.options.checkProof(info, nonce); err != nil {
+			return fmt.Errorf("%w: %w", ErrParasiteRefused, err)
+		}
+	}
+	if h.options.MinimalParasiteVersion != "" {
+		if err := checkParasiteVersion(info.Version, h.options.MinimalParasiteVersion); err != nil {
+			return fmt.Errorf("%w: %w", ErrParasiteRefused, err)
+		}
+	}
+	if h.options.CheckParasite != nil {
+		if err := h.options.CheckParasite(info); err != nil {
+			return fmt.Errorf("%w: %w", ErrParasiteRefused, err)
+		}
+	}
 	return nil
 }
 
-func (h *Host) Call(call string, data []byte) ([]byte, error) {
-	for _, plg := range h.parasites {
-		return plg.CallWithResponse(call, data)
+// accept adds a started parasite whose handshake passed checkParasite to the
+// host and registers the calls it advertises. Calls already served by another
+// parasite keep their route. A parasite replacing another one with the same
+// name drains it.
+func (h *Host) accept(e *Entity) error {
+	h.parasitesLocker.Lock()
+	defer h.parasitesLocker.Unlock()
+	if e.stopping.Load() {
+		// stopped while it was starting
+		return ErrStopped
+	}
+	if old, ok := h.parasites[e.name]; ok && old != e {
+		if e.attached && !old.attached && !h.options.AttachReplaces {
+			return fmt.Errorf("%w: %s is run by the host", ErrParasiteRefused, e.name)
+		}
+		go h.drain(old)
+		// a reloaded parasite replaces the routes of the previous version
+		for call, owner := range h.routes {
+			if owner == e.name {
+				delete(h.routes, call)
+			}
+		}
+	}
+	h.parasites[e.name] = e
+	for _, call := range e.info.Calls {
+		if owner, ok := h.routes[call]; ok && owner != e.name {
+			logger.Warn("call is already served by another parasite",
+				zap.String("call", call), zap.String("parasite_name", e.name), zap.String("owner", owner))
+			continue
+		}
+		h.routes[call] = e.name
 	}
-	return []byte(""), nil
+	return nil
 }
 
-func (h *Host) Notice(call string, data []byte) ([]byte, error) {
-	for _, plg := range h.parasites {
-		plg.Call(call, data)
-		return []byte(""), nil
+func checkParasiteVersion(version string, minimalVersion string) error {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+	minimal, err := ParseVersion(minimalVersion)
+	if err != nil {
+		return fmt.Errorf("minimal parasite version: %w", err)
+	}
+	if v.Compare(minimal) < 0 {
+		return fmt.Errorf("parasite version %s is older than %s", version, minimalVersion)
 	}
-	return []byte(""), nil
+	return nil
 }
 
-func (h *Host) dispatchCall(e *Entity, call string, data []byte, replyFunc func([]byte) error) {
-	switch call {
-	case callLogger:
-		log(e.name, data)
-	default:
-		reply, err := h.e.OnCall(call, data)
-		if err != nil {
-			replyFunc([]byte(err.Error()))
-			return
+func (h *Host) remove(e *Entity) {
+	h.parasitesLocker.Lock()
+	defer h.parasitesLocker.Unlock()
+	if h.parasites[e.name] != e {
+		return
+	}
+	delete(h.parasites, e.name)
+	for call, owner := range h.routes {
+		if owner == e.name {
+			delete(h.routes, call)
 		}
-		replyFunc(reply)
 	}
 }
 
-type logContent struct {
-	Level   string `json:"level"`
-	Caller  string `json:"caller"`
-	Message string `json:"msg"`
+// Route makes Call and Notice deliver call to the named parasite, overriding
+// the route registered from the calls the parasites advertise.
+func (h *Host) Route(call string, parasiteName string) {
+	h.parasitesLocker.Lock()
+	defer h.parasitesLocker.Unlock()
+	h.routes[call] = parasiteName
 }
 
-func log(parasiteName string, data []byte) {
-	c := logContent{}
-	err := json.Unmarshal(data, &c)
-	if err != nil {
-		return
+// Parasite returns the loaded parasite with the given name, which is its
+// file name without the ".exe" suffix.
+func (h *Host) Parasite(name string) (*Entity, bool) {
+	h.parasitesLocker.RLock()
+	defer h.parasitesLocker.RUnlock()
+	e, ok := h.parasites[name]
+	return e, ok
+}
+
+// Parasites returns the names of all loaded parasites.
+func (h *Host) Parasites() []string {
+	h.parasitesLocker.RLock()
+	defer h.parasitesLocker.RUnlock()
+	names := make([]string, 0, len(h.parasites))
+	for name := range h.parasites {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	fieldMap := map[string]interface{}{}
-	_ = json.Unmarshal(data, &fieldMap)
-	fields := []zap.Field{}
-	fields = append(fields, zap.String("parasite_name", parasiteName), zap.String("parasite_caller", c.Caller))
-	for k, v := range fieldMap {
-		if k == "level" ||
-			k == "ts" ||
-			k == "caller" ||
-			k == "msg" ||
-			k == "parasite_name" ||
-			k == "parasite_caller" {
-			continue
+// route finds the parasite serving call. A host with a single parasite
+// sends every unrouted call to it.
+func (h *Host) route(call string) (*Entity, error) {
+	h.parasitesLocker.RLock()
+	defer h.parasitesLocker.RUnlock()
+	if name, ok := h.routes[call]; ok {
+		e, ok := h.parasites[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrParasiteNotFound, name)
+		}
+		return e, nil
+	}
+	if len(h.parasites) == 1 {
+		for _, e := range h.parasites {
+			return e, nil
 		}
-		fields = append(fields, zap.Any(k, v))
-	}
-	c.Message = "[parasite] " + c.Message
-	switch c.Level {
-	case "debug":
-		logger.Debug(c.Message, fields...)
-	case "info":
-		logger.Info(c.Message, fields...)
-	case "warn":
-		logger.Warn(c.Message, fields...)
-	case "error":
-		logger.Error(c.Message, fields...)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoRoute, call)
+}
+
+func (h *Host) Call(call string, data []byte) ([]byte, error) {
+	e, err := h.route(call)
+	if err != nil {
+		return nil, err
+	}
+	return e.CallWithResponse(call, data)
+}
+
+// CallContext is Call bounded by ctx instead of the default timeout.
+func (h *Host) CallContext(ctx context.Context, call string, data []byte) ([]byte, error) {
+	e, err := h.route(call)
+	if err != nil {
+		return nil, err
+	}
+	return e.CallWithResponseContext(ctx, call, data)
+}
+
+func (h *Host) Notice(call string, data []byte) ([]byte, error) {
+	e, err := h.route(call)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(""), e.Call(call, data)
+}
+
+// CallParasite sends call to the named parasite and waits for its reply.
+func (h *Host) CallParasite(name string, call string, data []byte) ([]byte, error) {
+	e, ok := h.Parasite(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrParasiteNotFound, name)
+	}
+	return e.CallWithResponse(call, data)
+}
+
+// Shutdown shuts every parasite down with Entity.Shutdown and waits for all
+// of them to exit.
+func (h *Host) Shutdown(ctx context.Context) error {
+	h.parasitesLocker.RLock()
+	entities := make([]*Entity, 0, len(h.parasites))
+	for _, e := range h.parasites {
+		entities = append(entities, e)
+	}
+	h.parasitesLocker.RUnlock()
+
+	errs := make([]error, len(entities))
+	wg := sync.WaitGroup{}
+	for i, e := range entities {
+		wg.Add(1)
+		go func(i int, e *Entity) {
+			defer wg.Done()
+			errs[i] = e.Shutdown(ctx)
+		}(i, e)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+type BroadcastResult struct {
+	Content []byte
+	Err     error
+}
+
+// Broadcast sends call to every loaded parasite concurrently and collects
+// the replies keyed by parasite name.
+func (h *Host) Broadcast(call string, data []byte) map[string]*BroadcastResult {
+	h.parasitesLocker.RLock()
+	entities := make(map[string]*Entity, len(h.parasites))
+	for name, e := range h.parasites {
+		entities[name] = e
+	}
+	h.parasitesLocker.RUnlock()
+
+	results := make(map[string]*BroadcastResult, len(entities))
+	resultsLocker := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, e := range entities {
+		wg.Add(1)
+		go func(name string, e *Entity) {
+			defer wg.Done()
+			content, err := e.CallWithResponse(call, data)
+			resultsLocker.Lock()
+			results[name] = &BroadcastResult{
+				Content: content,
+				Err:     err,
+			}
+			resultsLocker.Unlock()
+		}(name, e)
+	}
+	wg.Wait()
+	return results
+}
+
+// dispatchCall handles a call from a parasite read from conn. Log entries
+// are handled in order on the reading goroutine, other calls on their own
+// goroutine so that the Executor may call the parasite back, and are aborted
+// when the parasite cancels them.
+func (h *Host) dispatchCall(e *Entity, conn *conn, req *sendObject) {
+	switch req.call {
+	case callLogger:
+		e.forwardLog(parseLogEntry(e.name, req.content))
+	default:
+		ctx, cancel, replyMD := handlerContext(context.Background(), req.metadata)
+		untrack := e.trackCall(conn, req.id, cancel)
+		go func() {
+			defer cancel()
+			defer untrack()
+			info := &CallInfo{Parasite: e.name, Call: req.call}
+			reply, err := intercept(ctx, h.incoming, info, req.content,
+				func(ctx context.Context, data []byte) ([]byte, error) {
+					if executor, ok := h.e.(ContextExecutor); ok {
+						return executor.OnCallContext(ctx, req.call, data)
+					}
+					return h.e.OnCall(req.call, data)
+				})
+			if errors.Is(ctx.Err(), context.Canceled) {
+				// the parasite has given up on the call
+				return
+			}
+			newReplyFunc(conn, req.id, req.call)(reply, replyMD.get(), err)
+		}()
 	}
 }
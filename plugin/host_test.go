@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// a serves "hello", "shared" and "fail", b serves "bye", "shared" and a
+	// failing "fail"
+	for name, calls := range map[string][]string{
+		"a": {"hello", "shared", "fail"},
+		"b": {"bye", "shared", "fail"},
+	} {
+		testParasites[name] = func(options *Options) {
+			for _, call := range calls {
+				RegisterHandler(call, handlerFunc(func(data []byte) ([]byte, error) {
+					if call == "fail" && name == "b" {
+						return nil, errors.New(name + " failed")
+					}
+					return []byte(name + "/" + call), nil
+				}))
+			}
+		}
+	}
+}
+
+// addTestEntities adds an entity which is not started for each name.
+func addTestEntities(h *Host, names ...string) {
+	for _, name := range names {
+		h.parasites[name] = newEntity(name, nil, h)
+	}
+}
+
+func TestHost_route(t *testing.T) {
+	h := NewHost("host", "1.0.0", nil)
+	addTestEntities(h, "a")
+	if e, err := h.route("hello"); err != nil || e.name != "a" {
+		t.Errorf("a single parasite should get every call, got %v", err)
+	}
+
+	addTestEntities(h, "b")
+	if strings.Join(h.Parasites(), ",") != "a,b" {
+		t.Errorf("unexpected parasites %v", h.Parasites())
+	}
+	if _, err := h.route("hello"); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("an unrouted call should fail with ErrNoRoute, got %v", err)
+	}
+	h.Route("hello", "b")
+	if e, err := h.route("hello"); err != nil || e.name != "b" {
+		t.Errorf("the call should follow the route, got %v", err)
+	}
+	h.Route("bye", "missing")
+	if _, err := h.Call("bye", nil); !errors.Is(err, ErrParasiteNotFound) {
+		t.Errorf("a call routed to a missing parasite should fail with ErrParasiteNotFound, got %v", err)
+	}
+	if _, err := h.Notice("unknown", nil); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("an unrouted notice should fail with ErrNoRoute, got %v", err)
+	}
+	if _, err := h.CallParasite("missing", "hello", nil); !errors.Is(err, ErrParasiteNotFound) {
+		t.Errorf("a missing parasite should fail with ErrParasiteNotFound, got %v", err)
+	}
+}
+
+func TestHost_Call(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	loadTestParasites(t, h, "a", "b")
+
+	for call, expected := range map[string]string{
+		"hello":  "a/hello",
+		"bye":    "b/bye",
+		"shared": "a/shared",
+	} {
+		if rsp, err := h.Call(call, nil); err != nil || string(rsp) != expected {
+			t.Errorf("%s: the call should be routed to the parasite advertising it, got %q %v", call, rsp, err)
+		}
+	}
+	if rsp, err := h.CallParasite("b", "shared", nil); err != nil || string(rsp) != "b/shared" {
+		t.Errorf("the call should reach the named parasite, got %q %v", rsp, err)
+	}
+	h.Route("shared", "b")
+	if rsp, err := h.Call("shared", nil); err != nil || string(rsp) != "b/shared" {
+		t.Errorf("the call should follow the route, got %q %v", rsp, err)
+	}
+	if _, err := h.Call("unknown", nil); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("a call no parasite serves should fail with ErrNoRoute, got %v", err)
+	}
+
+	results := h.Broadcast("fail", nil)
+	if len(results) != 2 {
+		t.Fatalf("every parasite should reply, got %v", results)
+	}
+	if r := results["a"]; r.Err != nil || string(r.Content) != "a/fail" {
+		t.Errorf("unexpected result of a %q %v", r.Content, r.Err)
+	}
+	var remoteErr *RemoteError
+	if r := results["b"]; !errors.As(r.Err, &remoteErr) || remoteErr.Message != "b failed" {
+		t.Errorf("the error of b should be collected, got %v", r.Err)
+	}
+}
+
+func TestHost_Broadcast(t *testing.T) {
+	h := NewHost("host", "1.0.0", nil)
+	addTestEntities(h, "a", "b")
+	for _, e := range h.parasites {
+		reader, writer := io.Pipe()
+		_ = reader.CloseWithError(errors.New(e.name + " is gone"))
+		e.proc = &process{conn: newConn(reader, writer)}
+	}
+
+	results := h.Broadcast("hello", nil)
+	if len(results) != 2 {
+		t.Fatalf("every parasite should get the call, got %v", results)
+	}
+	for _, name := range []string{"a", "b"} {
+		if r := results[name]; r == nil || r.Err == nil || r.Err.Error() != name+" is gone" {
+			t.Errorf("the error of %s should be collected, got %+v", name, r)
+		}
+	}
+}
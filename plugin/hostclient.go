@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// HostClient calls the Executor of the host a parasite runs in.
+type HostClient struct {
+	conn    *conn
+	pending pendingCalls
+	// parasite is the name of the parasite served and interceptor runs around
+	// its calls, both are set before Init
+	parasite    string
+	interceptor Interceptor
+}
+
+var defaultHostClient = &HostClient{conn: parasiteConn}
+
+// hostClient is the client of the host the parasite is served to, which is
+// defaultHostClient unless ServeParasite is serving another host.
+var hostClient atomic.Pointer[HostClient]
+
+func init() {
+	hostClient.Store(defaultHostClient)
+}
+
+// Call sends call to the host without waiting for the reply.
+func (c *HostClient) Call(call string, data []byte) error {
+	info := &CallInfo{Parasite: c.parasite, Call: call, Notice: true}
+	_, err := intercept(context.Background(), c.interceptor, info, data,
+		func(ctx context.Context, data []byte) ([]byte, error) {
+			return nil, c.conn.send(&sendObject{
+				id:      c.pending.newID(),
+				call:    call,
+				content: data,
+			})
+		})
+	return err
+}
+
+// CallWithResponse sends call to the host and waits for the reply of its
+// Executor until ctx is done. Errors returned by the Executor are returned as
+// *RemoteError, and the call fails with ErrHostClosed if the pipe to the host
+// is closed before the reply.
+func (c *HostClient) CallWithResponse(ctx context.Context, call string, data []byte) ([]byte, error) {
+	info := &CallInfo{Parasite: c.parasite, Call: call}
+	return intercept(ctx, c.interceptor, info, data, func(ctx context.Context, data []byte) ([]byte, error) {
+		return roundTrip(ctx, c.conn, &c.pending, call, data)
+	})
+}
+
+// CallHost sends call to the host without waiting for the reply. It may be
+// called from Parasite.Init on. Parasites loaded with Host.LoadParasite use
+// CallHostContext instead.
+func CallHost(call string, data []byte) error {
+	return hostClient.Load().Call(call, data)
+}
+
+// CallHostContext is CallHost sent to the host of the parasite whose call or
+// Init is run with ctx, see HostClientFromContext.
+func CallHostContext(ctx context.Context, call string, data []byte) error {
+	return HostClientFromContext(ctx).Call(call, data)
+}
+
+// CallHostWithResponse sends call to the host and waits for the reply until
+// ctx is done, see HostClient.CallWithResponse. It may be called from
+// Parasite.Init on. When ctx is the context of a handler or of
+// ParasiteV2.Init, the call goes to the host of that parasite, which is how
+// parasites loaded with Host.LoadParasite reach their host.
+func CallHostWithResponse(ctx context.Context, call string, data []byte) ([]byte, error) {
+	return HostClientFromContext(ctx).CallWithResponse(ctx, call, data)
+}
+
+type hostClientKey struct{}
+
+func withHostClient(ctx context.Context, client *HostClient) context.Context {
+	return context.WithValue(ctx, hostClientKey{}, client)
+}
+
+// HostClientFromContext returns the client of the host of the parasite whose
+// call or Init is run with ctx, the one CallHost uses otherwise.
+func HostClientFromContext(ctx context.Context) *HostClient {
+	if client, ok := ctx.Value(hostClientKey{}).(*HostClient); ok {
+		return client
+	}
+	return hostClient.Load()
+}
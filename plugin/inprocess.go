@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/delichik/daf/logger"
+	"go.uber.org/zap"
+)
+
+// ServeFunc serves a parasite running in the host process over r and w until
+// ctx is done, see ServeParasite.
+type ServeFunc func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error
+
+// funcRunner runs a ServeFunc on its own goroutine.
+type funcRunner struct {
+	cancel context.CancelFunc
+	// closers are the ends of the pipes the ServeFunc uses
+	closers []io.Closer
+	done    chan struct{}
+	killed  chan struct{}
+	err     error
+}
+
+func (r *funcRunner) wait() int {
+	select {
+	case <-r.done:
+	case <-r.killed:
+		// the goroutine can not be stopped, it is left to return once its
+		// pipes are closed
+		return -1
+	}
+	if r.err != nil {
+		return 1
+	}
+	return 0
+}
+
+func (r *funcRunner) terminate() error {
+	r.cancel()
+	return nil
+}
+
+func (r *funcRunner) kill() error {
+	select {
+	case <-r.killed:
+		return nil
+	default:
+	}
+	r.cancel()
+	for _, closer := range r.closers {
+		_ = closer.Close()
+	}
+	close(r.killed)
+	return nil
+}
+
+// funcSpawner runs serve on a goroutine connected to the host with in-memory
+// pipes.
+func funcSpawner(serve ServeFunc) spawner {
+	return func(e *Entity, handshake string) (*process, error) {
+		hostReader, parasiteWriter := io.Pipe()
+		parasiteReader, hostWriter := io.Pipe()
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &funcRunner{
+			cancel:  cancel,
+			closers: []io.Closer{parasiteReader, parasiteWriter},
+			done:    make(chan struct{}),
+			killed:  make(chan struct{}),
+		}
+		go func() {
+			defer close(r.done)
+			defer func() {
+				_ = parasiteReader.Close()
+				_ = parasiteWriter.Close()
+			}()
+			defer func() {
+				if p := recover(); p != nil {
+					logger.Error("parasite panicked", zap.String("parasite_name", e.name), zap.Any("panic", p))
+					r.err = fmt.Errorf("%w: %v", ErrHandlerPanic, p)
+				}
+			}()
+			r.err = serve(ctx, parasiteReader, parasiteWriter, handshake)
+		}()
+
+		return &process{
+			runner:  r,
+			conn:    newConn(hostReader, hostWriter),
+			closers: []io.Closer{hostReader, hostWriter},
+			exited:  make(chan struct{}),
+		}, nil
+	}
+}
+
+// LoadFunc loads a parasite named name that runs in the host process: serve
+// is called on its own goroutine with pipes to the host, and called again
+// when it returns unless the parasite is stopped, as a parasite executable is
+// restarted. A ServeFunc calling ServeParasite serves the handlers registered
+// in the host process.
+func (h *Host) LoadFunc(name string, serve ServeFunc) error {
+	return newEntity(name, funcSpawner(serve), h).Start()
+}
+
+// LoadParasite loads a parasite serving calls with parasite in the host
+// process, see AdaptParasite for a Parasite. It is served over in-memory
+// pipes like a parasite loaded with LoadFunc, so that it is called, routed
+// and measured as one running in its own process. options.Name names the
+// parasite, the versions and the name of the host are filled when they are
+// not set, as is the secret of a host that authenticates its parasites. The
+// parasite reaches the host with CallHostWithResponse and CallHostContext and
+// the context of its calls, and logs to it through the LogWriter of
+// HostClientFromContext.
+func (h *Host) LoadParasite(options *Options, parasite ParasiteV2, calls ...string) error {
+	handlers := newRegistry()
+	handlers.register(parasite, calls)
+
+	parasiteOptions := *options
+	if parasiteOptions.Version == "" {
+		parasiteOptions.Version = h.version
+	}
+	if parasiteOptions.HostName == "" {
+		parasiteOptions.HostName = h.name
+	}
+	if parasiteOptions.HostMinimalVersion == "" && parasiteOptions.HostVersionConstraint == "" {
+		parasiteOptions.HostMinimalVersion = h.version
+	}
+	if len(parasiteOptions.Secret) == 0 && len(parasiteOptions.PrivateKey) == 0 {
+		parasiteOptions.Secret = h.options.Secret
+	}
+	checkOptions(&parasiteOptions)
+
+	serve := func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error {
+		conn := newConn(r, w)
+		hostInfo, err := checkHandshake(handshake, &parasiteOptions)
+		if err != nil {
+			refuseHost(conn, err)
+			return err
+		}
+		return serveParasite(conn, &HostClient{conn: conn}, hostInfo, &parasiteOptions, handlers, ctx.Done(), nil)
+	}
+	return newEntity(parasiteOptions.Name, funcSpawner(serve), h).Start()
+}
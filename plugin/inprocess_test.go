@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// greeter asks the host for the name of the parasite calling it.
+type greeter struct{}
+
+func (greeter) Init(ctx context.Context) error {
+	_, err := CallHostWithResponse(ctx, "whoami", nil)
+	return err
+}
+
+func (greeter) Handle(ctx context.Context, call string, data []byte) ([]byte, error) {
+	name, err := CallHostWithResponse(ctx, "whoami", nil)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(call+" from "), name...), nil
+}
+
+func (greeter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestHost_LoadParasite(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{
+		Name:    "host",
+		Version: "1.0.0",
+		Secret:  []byte("secret"),
+		Executor: executorFunc(func(call string, data []byte) ([]byte, error) {
+			return nil, nil
+		}),
+		IncomingInterceptors: []Interceptor{
+			func(ctx context.Context, info *CallInfo, data []byte, next Invoker) ([]byte, error) {
+				return []byte(info.Parasite), nil
+			},
+		},
+	})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	if err := h.LoadParasite(&Options{Name: "a", Workers: 4}, greeter{}, "hello", "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.LoadParasite(&Options{Name: "b"}, AdaptParasite(echo{}), "echo"); err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := h.Call("hello", nil)
+	if err != nil || string(rsp) != "hello from a" {
+		t.Errorf("unexpected reply %q %v", rsp, err)
+	}
+	rsp, err = h.CallParasite("a", "bye", nil)
+	if err != nil || string(rsp) != "bye from a" {
+		t.Errorf("unexpected reply %q %v", rsp, err)
+	}
+	rsp, err = h.Call("echo", []byte("data"))
+	if err != nil || string(rsp) != "data" {
+		t.Errorf("unexpected reply %q %v", rsp, err)
+	}
+
+	e, _ := h.Parasite("a")
+	if calls := e.Calls(); len(calls) != 2 {
+		t.Errorf("the parasite should only advertise its calls, got %v", calls)
+	}
+	if m := e.Metrics(); m.Calls != 2 {
+		t.Errorf("the calls should be measured, got %+v", m)
+	}
+}
+
+func TestHost_LoadParasite_replace(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	first := &multiParasite{}
+	if err := h.LoadParasite(&Options{Name: "p"}, first, "x"); err != nil {
+		t.Fatal(err)
+	}
+	replaced, _ := h.Parasite("p")
+	if err := h.LoadParasite(&Options{Name: "p"}, &multiParasite{}, "y"); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, _ := h.Parasite("p"); e == replaced {
+		t.Fatal("the new parasite should replace the previous one")
+	}
+	waitFor(t, "the replaced parasite to be drained", func() bool {
+		first.locker.Lock()
+		defer first.locker.Unlock()
+		return first.shutdowns == 1 && replaced.currentConn() == nil
+	})
+	if rsp, err := h.Call("y", nil); err != nil || string(rsp) != "y" {
+		t.Errorf("unexpected reply %q %v", rsp, err)
+	}
+	if _, err := h.Call("x", nil); !errors.Is(err, ErrUnknownCall) {
+		t.Errorf("the calls of the replaced parasite should not be served, got %v", err)
+	}
+}
+
+// notifier sends a notice to its host and logs with a logger of its own.
+type notifier struct{}
+
+func (notifier) Init(ctx context.Context) error {
+	return nil
+}
+
+func (notifier) Handle(ctx context.Context, call string, data []byte) ([]byte, error) {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(HostClientFromContext(ctx).LogWriter()), zapcore.DebugLevel)
+	zap.New(core).Info("notifying", zap.String("call", call))
+	return nil, CallHostContext(ctx, "notified", data)
+}
+
+func (notifier) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestHost_LoadParasite_notices(t *testing.T) {
+	notices := make(chan string, 1)
+	sink := &recordingSink{}
+	h := NewHostWithOptions(&HostOptions{
+		Name:    "host",
+		Version: "1.0.0",
+		LogSink: sink,
+		Executor: executorFunc(func(call string, data []byte) ([]byte, error) {
+			notices <- call + " " + string(data)
+			return nil, nil
+		}),
+	})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	if err := h.LoadParasite(&Options{Name: "notifier"}, notifier{}, "notify"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.Call("notify", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if notice := <-notices; notice != "notified hello" {
+		t.Errorf("unexpected notice %q", notice)
+	}
+	sink.locker.Lock()
+	defer sink.locker.Unlock()
+	if len(sink.entries) != 1 || sink.entries[0].Parasite != "notifier" || sink.entries[0].Message != "notifying" {
+		t.Errorf("the log entry should reach the sink of the host, got %+v", sink.entries)
+	}
+}
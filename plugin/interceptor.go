@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/delichik/daf/logger"
+	"go.uber.org/zap"
+)
+
+// CallInfo describes the call an Interceptor runs around.
+type CallInfo struct {
+	// Parasite is the name of the parasite the call is sent to or comes from
+	// on the host, the name of the parasite itself on the parasite.
+	Parasite string
+	Call     string
+	// Notice is set for the calls sent without waiting for the reply, the
+	// reply they return is dropped. It is only known on the sending side.
+	Notice bool
+}
+
+// Invoker handles a call, or sends it to the other side of the pipe and
+// returns the reply.
+type Invoker func(ctx context.Context, data []byte) ([]byte, error)
+
+// Interceptor runs around a call: it calls next to go on with the call,
+// possibly with another ctx or data, or returns without calling it to fail
+// the call. Interceptors are called concurrently.
+type Interceptor func(ctx context.Context, info *CallInfo, data []byte, next Invoker) ([]byte, error)
+
+// ChainInterceptors returns an Interceptor running interceptors in order, the
+// first one being the outermost.
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(ctx context.Context, info *CallInfo, data []byte, next Invoker) ([]byte, error) {
+		return chainInvoker(interceptors, info, next)(ctx, data)
+	}
+}
+
+func chainInvoker(interceptors []Interceptor, info *CallInfo, handler Invoker) Invoker {
+	if len(interceptors) == 0 {
+		return handler
+	}
+	return func(ctx context.Context, data []byte) ([]byte, error) {
+		return interceptors[0](ctx, info, data, chainInvoker(interceptors[1:], info, handler))
+	}
+}
+
+// intercept runs handler through interceptor, which may be nil.
+func intercept(ctx context.Context, interceptor Interceptor, info *CallInfo, data []byte, handler Invoker) ([]byte, error) {
+	if interceptor == nil {
+		return handler(ctx, data)
+	}
+	return interceptor(ctx, info, data, handler)
+}
+
+// RecoveryInterceptor turns a panic of the rest of the chain into a
+// *RemoteError with CodeHandlerPanic, which is what the caller gets instead of
+// the process crashing. Parasites always run it first around the handlers.
+func RecoveryInterceptor(ctx context.Context, info *CallInfo, data []byte, next Invoker) (rsp []byte, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("handler panicked", zap.String("parasite_name", info.Parasite),
+				zap.String("call", info.Call), zap.Any("panic", p), zap.ByteString("stack", debug.Stack()))
+			rsp = nil
+			err = &RemoteError{
+				Code:    CodeHandlerPanic,
+				Message: fmt.Sprintf("%s: %v", ErrHandlerPanic, p),
+				Details: map[string]string{"call": info.Call},
+			}
+		}
+	}()
+	return next(ctx, data)
+}
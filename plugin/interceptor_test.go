@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type panicking struct{}
+
+func (panicking) Init() error { return nil }
+
+func (panicking) UnInit() {}
+
+func (panicking) Handle(data []byte) ([]byte, error) {
+	panic("boom")
+}
+
+type callingHost struct{}
+
+func (callingHost) Init() error { return nil }
+
+func (callingHost) UnInit() {}
+
+func (callingHost) Handle(data []byte) ([]byte, error) {
+	return CallHostWithResponse(context.Background(), "host_echo", data)
+}
+
+func init() {
+	RegisterHandler("panic", panicking{})
+	RegisterHandler("call_host", callingHost{})
+}
+
+type executorFunc func(call string, data []byte) ([]byte, error)
+
+func (f executorFunc) OnCall(call string, data []byte) ([]byte, error) {
+	return f(call, data)
+}
+
+// tagInterceptor appends tag to the data of the calls and records them.
+type tagInterceptor struct {
+	tag    string
+	calls  []string
+	locker sync.Mutex
+}
+
+func (i *tagInterceptor) intercept(ctx context.Context, info *CallInfo, data []byte, next Invoker) ([]byte, error) {
+	i.locker.Lock()
+	i.calls = append(i.calls, info.Parasite+"/"+info.Call)
+	i.locker.Unlock()
+	return next(ctx, append(append([]byte(nil), data...), i.tag...))
+}
+
+func TestChainInterceptors(t *testing.T) {
+	order := []string{}
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, info *CallInfo, data []byte, next Invoker) ([]byte, error) {
+			order = append(order, name)
+			return next(ctx, data)
+		}
+	}
+	reject := func(ctx context.Context, info *CallInfo, data []byte, next Invoker) ([]byte, error) {
+		return nil, errors.New("rejected")
+	}
+
+	chain := ChainInterceptors(record("a"), record("b"))
+	rsp, err := chain(context.Background(), &CallInfo{Call: "c"}, []byte("data"),
+		func(ctx context.Context, data []byte) ([]byte, error) {
+			order = append(order, "handler")
+			return data, nil
+		})
+	if err != nil || string(rsp) != "data" || strings.Join(order, ",") != "a,b,handler" {
+		t.Errorf("unexpected result %q %v %v", rsp, err, order)
+	}
+
+	order = nil
+	chain = ChainInterceptors(record("a"), reject, record("b"))
+	_, err = chain(context.Background(), &CallInfo{Call: "c"}, nil,
+		func(ctx context.Context, data []byte) ([]byte, error) {
+			order = append(order, "handler")
+			return nil, nil
+		})
+	if err == nil || strings.Join(order, ",") != "a" {
+		t.Errorf("the chain should stop at the rejecting interceptor, got %v %v", err, order)
+	}
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	_, err := RecoveryInterceptor(context.Background(), &CallInfo{Call: "c"}, nil,
+		func(ctx context.Context, data []byte) ([]byte, error) {
+			panic("boom")
+		})
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Code != CodeHandlerPanic || remoteErr.Details["call"] != "c" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	hostOut := &tagInterceptor{tag: "+host_out"}
+	hostIn := &tagInterceptor{tag: "+host_in"}
+	parasiteIn := &tagInterceptor{tag: "+parasite_in"}
+	parasiteOut := &tagInterceptor{tag: "+parasite_out"}
+	h := NewHostWithOptions(&HostOptions{
+		Name:    "host",
+		Version: "1.0.0",
+		Executor: executorFunc(func(call string, data []byte) ([]byte, error) {
+			return data, nil
+		}),
+		OutgoingInterceptors: []Interceptor{hostOut.intercept},
+		IncomingInterceptors: []Interceptor{hostIn.intercept},
+	})
+	e := loadTestParasiteWithOptions(t, h, &Options{
+		IncomingInterceptors: []Interceptor{parasiteIn.intercept},
+		OutgoingInterceptors: []Interceptor{parasiteOut.intercept},
+	})
+
+	rsp, err := e.CallWithResponse("call_host", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp) != "data+host_out+parasite_in+parasite_out+host_in" {
+		t.Errorf("every interceptor should run once, got %q", rsp)
+	}
+	for _, i := range []*tagInterceptor{hostOut, hostIn, parasiteIn, parasiteOut} {
+		if len(i.calls) != 1 {
+			t.Errorf("%s: unexpected calls %v", i.tag, i.calls)
+		}
+	}
+	if hostIn.calls[0] != "test/host_echo" || parasiteIn.calls[0] != "test/call_host" {
+		t.Errorf("unexpected call infos %v %v", hostIn.calls, parasiteIn.calls)
+	}
+
+	_, err = e.CallWithResponse("panic", nil)
+	if !errors.Is(err, ErrHandlerPanic) {
+		t.Fatalf("a panic should be reported as ErrHandlerPanic, got %v", err)
+	}
+	if _, err = e.CallWithResponse("echo", nil); err != nil {
+		t.Errorf("the parasite should survive the panic: %v", err)
+	}
+}
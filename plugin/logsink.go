@@ -0,0 +1,236 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/delichik/daf/logger"
+)
+
+// LogEntry is a log entry of a parasite, either forwarded by its logger or a
+// line it printed to its standard output or error.
+type LogEntry struct {
+	Parasite string
+	Level    zapcore.Level
+	// Time is the time the parasite logged the entry at, the time the host
+	// received it if the entry has none.
+	Time    time.Time
+	Caller  string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// LogSink receives the log entries of the parasites that pass the level and
+// rate limits of the host. Log is called from the goroutine reading from the
+// parasite and must not block.
+type LogSink interface {
+	Log(entry *LogEntry)
+}
+
+// zapFields returns the fields of the entry sorted by key, along with the
+// name of the parasite and where the entry comes from.
+func (entry *LogEntry) zapFields() []zap.Field {
+	fields := []zap.Field{zap.String("parasite_name", entry.Parasite)}
+	if entry.Caller != "" {
+		fields = append(fields, zap.String("parasite_caller", entry.Caller))
+	}
+	keys := make([]string, 0, len(entry.Fields))
+	for k := range entry.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, zap.Any(k, entry.Fields[k]))
+	}
+	if entry.Level > zapcore.ErrorLevel {
+		// the host must not panic or exit because of a parasite
+		fields = append(fields, zap.String("parasite_level", entry.Level.String()))
+	}
+	return fields
+}
+
+// loggerSink logs the entries with the default logger, which can not keep
+// their time so it is added as a field.
+type loggerSink struct{}
+
+func (loggerSink) Log(entry *LogEntry) {
+	message := "[parasite] " + entry.Message
+	fields := append(entry.zapFields(), zap.Time("parasite_ts", entry.Time))
+	switch {
+	case entry.Level <= zapcore.DebugLevel:
+		logger.Debug(message, fields...)
+	case entry.Level == zapcore.InfoLevel:
+		logger.Info(message, fields...)
+	case entry.Level == zapcore.WarnLevel:
+		logger.Warn(message, fields...)
+	default:
+		logger.Error(message, fields...)
+	}
+}
+
+type zapSink struct {
+	logger *zap.Logger
+}
+
+// NewZapLogSink returns a LogSink logging the entries of the parasites with
+// l, at the time the parasites logged them. Entries above the error level
+// are logged as errors.
+func NewZapLogSink(l *zap.Logger) LogSink {
+	return &zapSink{logger: l}
+}
+
+func (s *zapSink) Log(entry *LogEntry) {
+	level := entry.Level
+	if level > zapcore.ErrorLevel {
+		level = zapcore.ErrorLevel
+	}
+	checked := s.logger.Check(level, "[parasite] "+entry.Message)
+	if checked == nil {
+		return
+	}
+	checked.Time = entry.Time
+	checked.Write(entry.zapFields()...)
+}
+
+// logLevel returns the minimum level of the entries of the named parasite.
+func (h *Host) logLevel(name string) zapcore.Level {
+	text, ok := h.options.LogLevels[name]
+	if !ok {
+		text = h.options.LogLevel
+	}
+	if text == "" {
+		return zapcore.DebugLevel
+	}
+	level, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return zapcore.DebugLevel
+	}
+	return level
+}
+
+func (h *Host) logSink() LogSink {
+	if h.options.LogSink != nil {
+		return h.options.LogSink
+	}
+	return loggerSink{}
+}
+
+// logLimiter drops the entries of a parasite above LogRateLimit per second.
+type logLimiter struct {
+	windowStart time.Time
+	count       int
+	dropped     int
+	locker      sync.Mutex
+}
+
+// allow reports whether an entry may be logged now. It also returns how many
+// entries were dropped during the previous window once it is over.
+func (l *logLimiter) allow(limit int, now time.Time) (bool, int) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	dropped := 0
+	if now.Sub(l.windowStart) >= time.Second {
+		dropped = l.dropped
+		l.windowStart = now
+		l.count = 0
+		l.dropped = 0
+	}
+	if l.count >= limit {
+		l.dropped++
+		return false, dropped
+	}
+	l.count++
+	return true, dropped
+}
+
+// forwardLog hands entry to the log sink if it passes the level and the rate
+// limit of the parasite.
+func (e *Entity) forwardLog(entry *LogEntry) {
+	if entry.Level < e.host.logLevel(e.name) {
+		return
+	}
+	sink := e.host.logSink()
+	if limit := e.host.options.LogRateLimit; limit > 0 {
+		ok, dropped := e.logLimiter.allow(limit, time.Now())
+		if dropped > 0 {
+			sink.Log(&LogEntry{
+				Parasite: e.name,
+				Level:    zapcore.WarnLevel,
+				Time:     time.Now(),
+				Message:  fmt.Sprintf("%d log entries dropped by the rate limit", dropped),
+			})
+		}
+		if !ok {
+			return
+		}
+	}
+	sink.Log(entry)
+}
+
+// parseLogEntry decodes an entry the zap JSON encoder of a parasite wrote.
+// Malformed entries are kept as a warning holding the raw data.
+func parseLogEntry(parasite string, data []byte) *LogEntry {
+	entry := &LogEntry{
+		Parasite: parasite,
+		Level:    zapcore.InfoLevel,
+		Time:     time.Now(),
+		Fields:   map[string]interface{}{},
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		entry.Level = zapcore.WarnLevel
+		entry.Message = strings.TrimSpace(string(data))
+		entry.Fields["parse_error"] = err.Error()
+		return entry
+	}
+
+	for k, v := range fields {
+		switch k {
+		case "level":
+			text, _ := v.(string)
+			level, err := zapcore.ParseLevel(text)
+			if err != nil {
+				entry.Fields["parasite_level"] = v
+				continue
+			}
+			entry.Level = level
+		case "ts":
+			if t, ok := parseLogTime(v); ok {
+				entry.Time = t
+			}
+		case "caller":
+			entry.Caller, _ = v.(string)
+		case "msg":
+			entry.Message, _ = v.(string)
+		case "parasite_name", "parasite_caller":
+		default:
+			entry.Fields[k] = v
+		}
+	}
+	return entry
+}
+
+// parseLogTime decodes the times zap encodes: seconds since the epoch by
+// default, or ISO8601 and RFC3339 strings.
+func parseLogTime(v interface{}) (time.Time, bool) {
+	switch ts := v.(type) {
+	case float64:
+		seconds, fraction := math.Modf(ts)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700"} {
+			if t, err := time.Parse(layout, ts); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
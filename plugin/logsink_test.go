@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type recordingSink struct {
+	entries []*LogEntry
+	locker  sync.Mutex
+}
+
+func (s *recordingSink) Log(entry *LogEntry) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.entries = append(s.entries, entry)
+}
+
+func TestParseLogEntry(t *testing.T) {
+	tests := []struct {
+		data  string
+		level zapcore.Level
+	}{
+		{`{"level":"debug","msg":"m"}`, zapcore.DebugLevel},
+		{`{"level":"warn","msg":"m"}`, zapcore.WarnLevel},
+		{`{"level":"dpanic","msg":"m"}`, zapcore.DPanicLevel},
+		{`{"level":"panic","msg":"m"}`, zapcore.PanicLevel},
+		{`{"level":"fatal","msg":"m"}`, zapcore.FatalLevel},
+		{`{"level":"custom","msg":"m"}`, zapcore.InfoLevel},
+	}
+	for _, test := range tests {
+		entry := parseLogEntry("p", []byte(test.data))
+		if entry.Level != test.level || entry.Message != "m" {
+			t.Errorf("%s: unexpected entry %+v", test.data, entry)
+		}
+	}
+
+	entry := parseLogEntry("p", []byte(`{"level":"info","ts":1700000000.5,"caller":"a.go:1","msg":"m","k":"v"}`))
+	if !entry.Time.Equal(time.Unix(1700000000, 500000000)) || entry.Caller != "a.go:1" || entry.Fields["k"] != "v" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if _, ok := entry.Fields["ts"]; ok {
+		t.Error("ts should not be kept as a field")
+	}
+	entry = parseLogEntry("p", []byte(`{"level":"info","ts":"2024-01-02T03:04:05.678Z","msg":"m"}`))
+	if entry.Time.Year() != 2024 || entry.Time.Nanosecond() != 678000000 {
+		t.Errorf("unexpected time %v", entry.Time)
+	}
+
+	entry = parseLogEntry("p", []byte("not json\n"))
+	if entry.Level != zapcore.WarnLevel || entry.Message != "not json" || entry.Fields["parse_error"] == nil {
+		t.Errorf("malformed entries should be kept, got %+v", entry)
+	}
+}
+
+func TestEntity_forwardLog(t *testing.T) {
+	sink := &recordingSink{}
+	h := NewHostWithOptions(&HostOptions{
+		LogSink:      sink,
+		LogLevel:     "info",
+		LogLevels:    map[string]string{"quiet": "error"},
+		LogRateLimit: 2,
+	})
+	e := newEntity("p", nil, h)
+	quiet := newEntity("quiet", nil, h)
+
+	e.forwardLog(&LogEntry{Parasite: "p", Level: zapcore.DebugLevel})
+	quiet.forwardLog(&LogEntry{Parasite: "quiet", Level: zapcore.WarnLevel})
+	for i := 0; i < 5; i++ {
+		e.forwardLog(&LogEntry{Parasite: "p", Level: zapcore.InfoLevel})
+	}
+	if len(sink.entries) != 2 {
+		t.Fatalf("expected the 2 entries allowed by the limits, got %d", len(sink.entries))
+	}
+
+	e.logLimiter.windowStart = time.Now().Add(-time.Second)
+	e.forwardLog(&LogEntry{Parasite: "p", Level: zapcore.ErrorLevel})
+	if len(sink.entries) != 4 || sink.entries[2].Message != "3 log entries dropped by the rate limit" {
+		t.Errorf("the dropped entries should be reported, got %d entries", len(sink.entries))
+	}
+}
+
+func TestZapLogSink(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	sink := NewZapLogSink(zap.New(core))
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink.Log(&LogEntry{
+		Parasite: "p",
+		Level:    zapcore.FatalLevel,
+		Time:     ts,
+		Message:  "m",
+		Fields:   map[string]interface{}{"k": "v"},
+	})
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	fields := entry.ContextMap()
+	if entry.Level != zapcore.ErrorLevel || !entry.Time.Equal(ts) || entry.Message != "[parasite] m" ||
+		fields["k"] != "v" || fields["parasite_name"] != "p" || fields["parasite_level"] != "fatal" {
+		t.Errorf("unexpected entry %+v %v", entry.Entry, fields)
+	}
+}
@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/delichik/daf/logger"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestSuffix ends the name of a manifest next to the executable, such as
+// "foo.plugin.yaml", while a subdirectory holds a "plugin.yaml".
+const manifestSuffix = ".plugin"
+
+var manifestExtensions = []string{".json", ".yaml", ".yml"}
+
+// Manifest describes how to run a parasite. It is read from a plugin.json,
+// plugin.yaml or plugin.yml file in a subdirectory of the parasite directory,
+// or from a file named after the parasite such as foo.plugin.yaml next to the
+// executable.
+type Manifest struct {
+	// Name is the name of the parasite, the name of the subdirectory or the
+	// manifest file without its suffix if it is not set.
+	Name string `json:"name" yaml:"name"`
+	// Executable is the path of the parasite relative to the manifest, Name
+	// if it is not set.
+	Executable string `json:"executable" yaml:"executable"`
+	// Args are passed to the parasite after the handshake.
+	Args []string          `json:"args" yaml:"args"`
+	Env  map[string]string `json:"env" yaml:"env"`
+	// WorkingDir is relative to the manifest, the working directory of the
+	// host if it is not set.
+	WorkingDir string `json:"working_dir" yaml:"working_dir"`
+	// Enabled disables the parasite when it is false.
+	Enabled *bool `json:"enabled" yaml:"enabled"`
+	// HostVersion is a VersionConstraint the version of the host must
+	// satisfy for the parasite to be loaded.
+	HostVersion string `json:"host_version" yaml:"host_version"`
+
+	// dir is the directory paths in the manifest are relative to
+	dir string
+	// path is the file the manifest is read from, empty for an executable
+	// without manifest
+	path string
+}
+
+// ReadManifest reads a JSON or YAML manifest depending on the extension of
+// path.
+func ReadManifest(path string) (*Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(content, m)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, m)
+	default:
+		return nil, fmt.Errorf("unknown manifest format %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s: %w", path, err)
+	}
+
+	m.dir = filepath.Dir(path)
+	m.path = path
+	if m.Name == "" {
+		m.Name = manifestName(path)
+	}
+	return m, nil
+}
+
+// manifestName returns the name of the parasite of the manifest at path when
+// the manifest does not set it.
+func manifestName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if base == "plugin" {
+		return filepath.Base(filepath.Dir(path))
+	}
+	return strings.TrimSuffix(base, manifestSuffix)
+}
+
+func (m *Manifest) enabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+func (m *Manifest) executablePath() string {
+	executable := m.Executable
+	if executable == "" {
+		executable = m.Name
+		if runtime.GOOS == "windows" {
+			executable += ".exe"
+		}
+	}
+	if filepath.IsAbs(executable) {
+		return executable
+	}
+	return filepath.Join(m.dir, executable)
+}
+
+func (m *Manifest) workingDir() string {
+	if m.WorkingDir == "" || filepath.IsAbs(m.WorkingDir) {
+		return m.WorkingDir
+	}
+	return filepath.Join(m.dir, m.WorkingDir)
+}
+
+// isManifest reports whether name is the name of a manifest next to an
+// executable.
+func isManifest(name string) bool {
+	for _, ext := range manifestExtensions {
+		if strings.HasSuffix(name, manifestSuffix+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// findManifest returns the path of the manifest in dir, if there is one.
+func findManifest(dir string) (string, bool) {
+	for _, ext := range manifestExtensions {
+		path := filepath.Join(dir, "plugin"+ext)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// isExecutable reports whether a file without a manifest is run as a
+// parasite, which on Windows depends on the ".exe" suffix.
+func isExecutable(entry fs.DirEntry) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(entry.Name()), ".exe")
+	}
+	info, err := entry.Info()
+	return err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0
+}
+
+// readManifests finds the parasites in dir: the subdirectories and files with
+// a manifest, then the executables no manifest refers to unless only parasites
+// with a manifest are loaded. A manifest that can not be read, such as one
+// still being written, is logged and its parasite is returned in broken
+// instead, its executable is not run without it.
+func (h *Host) readManifests(dir string) (manifests []*Manifest, broken []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifests = []*Manifest{}
+	claimed := map[string]bool{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			var ok bool
+			if path, ok = findManifest(path); !ok {
+				continue
+			}
+		} else if !isManifest(entry.Name()) {
+			continue
+		}
+		m, err := ReadManifest(path)
+		if err != nil {
+			logger.Error("fail to read manifest", zap.String("path", path), zap.Error(err))
+			m = &Manifest{Name: manifestName(path), dir: filepath.Dir(path)}
+			claimed[m.executablePath()] = true
+			broken = append(broken, m.Name)
+			continue
+		}
+		claimed[m.executablePath()] = true
+		manifests = append(manifests, m)
+	}
+
+	if h.options.RequireManifest {
+		return manifests, broken, nil
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || isManifest(entry.Name()) || claimed[path] ||
+			h.options.skipFile(path) || !isExecutable(entry) {
+			continue
+		}
+		manifests = append(manifests, &Manifest{
+			Name:       strings.TrimSuffix(entry.Name(), ".exe"),
+			Executable: entry.Name(),
+			dir:        dir,
+		})
+	}
+	return manifests, broken, nil
+}
+
+// selected reports whether the parasite named name matches the Parasites
+// of the host.
+func (h *Host) selected(name string) bool {
+	if len(h.options.Parasites) == 0 {
+		return true
+	}
+	for _, pattern := range h.options.Parasites {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestReadManifests(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("executables are told apart by their suffix on windows")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"README.md":            "not a parasite",
+		"plain":                "#!/bin/sh\n",
+		"foo":                  "#!/bin/sh\n",
+		"foo.plugin.json":      `{"args": ["-v"], "env": {"A": "1"}}`,
+		"bar/plugin.yaml":      "name: baz\nexecutable: bin/bar\nworking_dir: data\nenabled: false\nhost_version: '>=1.2 <2'\n",
+		"bar/bin/bar":          "#!/bin/sh\n",
+		"empty/not-a-parasite": "#!/bin/sh\n",
+		"broken":               "#!/bin/sh\n",
+		"broken.plugin.yaml":   "name: [",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		mode := os.FileMode(0o644)
+		if content == "#!/bin/sh\n" {
+			mode = 0o755
+		}
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	manifests, broken, err := h.readManifests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 1 || broken[0] != "broken" {
+		t.Errorf("the manifest that can not be read should be reported, got %v", broken)
+	}
+	byName := map[string]*Manifest{}
+	names := []string{}
+	for _, m := range manifests {
+		byName[m.Name] = m
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "baz" || names[1] != "foo" || names[2] != "plain" {
+		t.Fatalf("unexpected parasites %v", names)
+	}
+
+	foo := byName["foo"]
+	if foo.executablePath() != filepath.Join(dir, "foo") || len(foo.Args) != 1 || foo.Env["A"] != "1" {
+		t.Errorf("unexpected manifest %+v", foo)
+	}
+	baz := byName["baz"]
+	if baz.executablePath() != filepath.Join(dir, "bar/bin/bar") || baz.workingDir() != filepath.Join(dir, "bar/data") {
+		t.Errorf("unexpected paths %s %s", baz.executablePath(), baz.workingDir())
+	}
+	if err = h.loadManifest(baz); !errors.Is(err, ErrParasiteDisabled) {
+		t.Errorf("a disabled parasite should not be loaded, got %v", err)
+	}
+	enabled := true
+	baz.Enabled = &enabled
+	if err = h.loadManifest(baz); !errors.Is(err, ErrParasiteRefused) {
+		t.Errorf("a parasite requiring another host version should be refused, got %v", err)
+	}
+
+	h = NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", RequireManifest: true, Parasites: []string{"f*"}})
+	manifests, _, err = h.readManifests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 {
+		t.Errorf("only parasites with a manifest should be found, got %d", len(manifests))
+	}
+	if !h.selected("foo") || h.selected("baz") {
+		t.Error("only parasites matching the patterns should be selected")
+	}
+	if err = h.LoadOne(filepath.Join(dir, "empty")); err == nil {
+		t.Error("a directory without manifest should not be loaded")
+	}
+}
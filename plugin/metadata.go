@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MetadataDeadline is the key of the deadline of a call in its metadata, in
+// the RFC 3339 format. It is set from the deadline of the context of the call
+// and the handler of the other side gets a context with the same deadline.
+const MetadataDeadline = "deadline"
+
+// Metadata is a set of keys and values sent along with a call or its reply,
+// such as a trace id, the identity of the caller or a locale. It is dropped
+// when the other side is too old to support it.
+type Metadata map[string]string
+
+type outgoingMetadataKey struct{}
+
+type incomingMetadataKey struct{}
+
+type replyMetadataKey struct{}
+
+type replyTargetKey struct{}
+
+// WithMetadata returns a context whose calls send md along with the metadata
+// of ctx.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := Metadata{}
+	for k, v := range outgoingMetadata(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+}
+
+func outgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md
+}
+
+// MetadataFromContext returns the metadata the other side sent with the call
+// handled with ctx, nil if there is none. It must not be modified.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md
+}
+
+// SetReplyMetadata adds md to the metadata sent with the reply to the call
+// handled with ctx. It does nothing if ctx is not the context of a call.
+func SetReplyMetadata(ctx context.Context, md Metadata) {
+	reply, ok := ctx.Value(replyMetadataKey{}).(*replyMetadata)
+	if !ok {
+		return
+	}
+	reply.locker.Lock()
+	defer reply.locker.Unlock()
+	if reply.md == nil {
+		reply.md = Metadata{}
+	}
+	for k, v := range md {
+		reply.md[k] = v
+	}
+}
+
+// WithReplyMetadata returns a context whose calls store the metadata of their
+// reply in md.
+func WithReplyMetadata(ctx context.Context, md *Metadata) context.Context {
+	return context.WithValue(ctx, replyTargetKey{}, md)
+}
+
+// replyMetadata collects the metadata a handler sets for its reply.
+type replyMetadata struct {
+	md     Metadata
+	locker sync.Mutex
+}
+
+func (r *replyMetadata) get() Metadata {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.md
+}
+
+// requestMetadata returns the metadata sent with a call made with ctx.
+func requestMetadata(ctx context.Context) Metadata {
+	md := outgoingMetadata(ctx)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return md
+	}
+	withDeadline := make(Metadata, len(md)+1)
+	for k, v := range md {
+		withDeadline[k] = v
+	}
+	withDeadline[MetadataDeadline] = deadline.Format(time.RFC3339Nano)
+	return withDeadline
+}
+
+// storeReplyMetadata hands the metadata of a reply to the caller that asked
+// for it with WithReplyMetadata.
+func storeReplyMetadata(ctx context.Context, md Metadata) {
+	if target, ok := ctx.Value(replyTargetKey{}).(*Metadata); ok {
+		*target = md
+	}
+}
+
+// handlerContext returns the context a call sent with md is handled with,
+// bounded by the deadline of the caller, and where the handler sets the
+// metadata of its reply.
+func handlerContext(parent context.Context, md Metadata) (context.Context, context.CancelFunc, *replyMetadata) {
+	reply := &replyMetadata{}
+	ctx := context.WithValue(parent, incomingMetadataKey{}, md)
+	ctx = context.WithValue(ctx, replyMetadataKey{}, reply)
+	if deadline, err := time.Parse(time.RFC3339Nano, md[MetadataDeadline]); err == nil {
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		return ctx, cancel, reply
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, reply
+}
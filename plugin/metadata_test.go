@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type metadataHandler struct{}
+
+func (metadataHandler) Init() error { return nil }
+
+func (metadataHandler) UnInit() {}
+
+func (metadataHandler) Handle(data []byte) ([]byte, error) {
+	return nil, nil
+}
+
+// HandleContext calls the host with the trace id of the call and replies
+// with the locale of the call and the reply of the host.
+func (metadataHandler) HandleContext(ctx context.Context, data []byte) ([]byte, error) {
+	md := MetadataFromContext(ctx)
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errDeadlineMissing
+	}
+	ctx = WithMetadata(ctx, Metadata{"trace_id": md["trace_id"]})
+	rsp, err := CallHostWithResponse(ctx, "host_metadata", nil)
+	if err != nil {
+		return nil, err
+	}
+	SetReplyMetadata(ctx, Metadata{"served_by": "test"})
+	return append([]byte(md["locale"]+"/"), rsp...), nil
+}
+
+var errDeadlineMissing = errors.New("the deadline is missing")
+
+func init() {
+	RegisterHandler("metadata", metadataHandler{})
+}
+
+type metadataExecutor struct{}
+
+func (metadataExecutor) OnCall(call string, data []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (metadataExecutor) OnCallContext(ctx context.Context, call string, data []byte) ([]byte, error) {
+	return []byte(MetadataFromContext(ctx)["trace_id"]), nil
+}
+
+func TestMetadata(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", Executor: metadataExecutor{}})
+	e := loadTestParasite(t, h)
+
+	replyMD := Metadata{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = WithMetadata(ctx, Metadata{"trace_id": "abc", "locale": "fr"})
+	ctx = WithReplyMetadata(ctx, &replyMD)
+	rsp, err := e.CallWithResponseContext(ctx, "metadata", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp) != "fr/abc" {
+		t.Errorf("the metadata should reach the parasite and the host, got %q", rsp)
+	}
+	if replyMD["served_by"] != "test" {
+		t.Errorf("unexpected reply metadata %v", replyMD)
+	}
+}
+
+func TestConn_metadataDisabled(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := newConn(buf, buf)
+	req := &sendObject{id: 1, call: "test", metadata: Metadata{"trace_id": "abc"}}
+	if err := c.send(req); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(buf.Bytes(), []byte(splitter)) != 2 {
+		t.Errorf("metadata should not be sent before the other side supports it: %q", buf.String())
+	}
+	if req.metadata == nil {
+		t.Error("the frame should not be modified")
+	}
+
+	buf.Reset()
+	c.enableMetadata()
+	if err := c.send(req); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := c.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.metadata["trace_id"] != "abc" {
+		t.Errorf("unexpected frame %+v", rsp)
+	}
+}
+
+// blockingExecutor blocks every call until its context is done, which it
+// reports to canceled.
+type blockingExecutor struct {
+	called   chan struct{}
+	canceled chan error
+}
+
+func (blockingExecutor) OnCall(call string, data []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (e blockingExecutor) OnCallContext(ctx context.Context, call string, data []byte) ([]byte, error) {
+	e.called <- struct{}{}
+	<-ctx.Done()
+	e.canceled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestHost_cancelCall(t *testing.T) {
+	executor := blockingExecutor{called: make(chan struct{}, 1), canceled: make(chan error, 1)}
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", Executor: executor})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	if err := h.LoadParasite(&Options{Name: "caller"}, hostCaller{}, "ask"); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := h.Parasite("caller")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-executor.called
+		cancel()
+	}()
+	if _, err := e.CallWithResponseContext(ctx, "ask", nil); !errors.Is(err, ErrCanceled) {
+		t.Fatalf("the call should be canceled, got %v", err)
+	}
+	select {
+	case err := <-executor.canceled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("the call of the parasite should be canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the Executor should see the call canceled by the parasite")
+	}
+}
@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram of the calls
+// made to parasites.
+var LatencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// MetricsSink receives the measurements of the parasites of a host, to
+// export them to a monitoring system. Its methods are called on the path of
+// every call and must not block.
+type MetricsSink interface {
+	CallStarted(parasite string, call string)
+	// CallFinished is called once the call is sent, for calls without reply,
+	// or once its reply is received. bytesOut and bytesIn are the sizes of the
+	// request and the reply.
+	CallFinished(parasite string, call string, latency time.Duration, bytesOut int, bytesIn int, err error)
+	Restarted(parasite string)
+}
+
+// Metrics is a snapshot of the measurements of a parasite since it is
+// loaded.
+type Metrics struct {
+	Calls    uint64
+	Errors   uint64
+	Timeouts uint64
+	InFlight int64
+	BytesOut uint64
+	BytesIn  uint64
+	Restarts uint64
+	// LatencyCounts counts the calls by LatencyBuckets, the last count is
+	// for the calls slower than every bucket.
+	LatencyCounts []uint64
+	LatencySum    time.Duration
+	Healthy       bool
+}
+
+// entityMetrics are the counters behind Metrics.
+type entityMetrics struct {
+	calls         atomic.Uint64
+	errors        atomic.Uint64
+	timeouts      atomic.Uint64
+	inFlight      atomic.Int64
+	bytesOut      atomic.Uint64
+	bytesIn       atomic.Uint64
+	restarts      atomic.Uint64
+	latencyCounts [len(LatencyBuckets) + 1]atomic.Uint64
+	latencySum    atomic.Int64
+}
+
+func (m *entityMetrics) observe(latency time.Duration) {
+	bucket := len(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+	m.latencyCounts[bucket].Add(1)
+	m.latencySum.Add(int64(latency))
+}
+
+// startCall counts a call to the parasite until finishCall is called with
+// the returned time.
+func (e *Entity) startCall(call string) time.Time {
+	e.metrics.inFlight.Add(1)
+	if sink := e.host.options.MetricsSink; sink != nil {
+		sink.CallStarted(e.name, call)
+	}
+	return time.Now()
+}
+
+func (e *Entity) finishCall(call string, start time.Time, bytesOut int, bytesIn int, err error) {
+	latency := time.Since(start)
+	m := &e.metrics
+	m.inFlight.Add(-1)
+	m.calls.Add(1)
+	m.bytesOut.Add(uint64(bytesOut))
+	m.bytesIn.Add(uint64(bytesIn))
+	m.observe(latency)
+	if err != nil {
+		m.errors.Add(1)
+		if errors.Is(err, ErrTimeout) {
+			m.timeouts.Add(1)
+		}
+	}
+	if sink := e.host.options.MetricsSink; sink != nil {
+		sink.CallFinished(e.name, call, latency, bytesOut, bytesIn, err)
+	}
+}
+
+func (e *Entity) restarted() {
+	e.metrics.restarts.Add(1)
+	if sink := e.host.options.MetricsSink; sink != nil {
+		sink.Restarted(e.name)
+	}
+}
+
+// Metrics returns a snapshot of the measurements of the parasite.
+func (e *Entity) Metrics() *Metrics {
+	m := &e.metrics
+	snapshot := &Metrics{
+		Calls:         m.calls.Load(),
+		Errors:        m.errors.Load(),
+		Timeouts:      m.timeouts.Load(),
+		InFlight:      m.inFlight.Load(),
+		BytesOut:      m.bytesOut.Load(),
+		BytesIn:       m.bytesIn.Load(),
+		Restarts:      m.restarts.Load(),
+		LatencyCounts: make([]uint64, len(LatencyBuckets)+1),
+		LatencySum:    time.Duration(m.latencySum.Load()),
+		Healthy:       e.Healthy(),
+	}
+	for i := range snapshot.LatencyCounts {
+		snapshot.LatencyCounts[i] = m.latencyCounts[i].Load()
+	}
+	return snapshot
+}
+
+// WritePrometheus writes the metrics of every parasite in the Prometheus text
+// exposition format.
+func (h *Host) WritePrometheus(w io.Writer) error {
+	names := h.Parasites()
+	metrics := make(map[string]*Metrics, len(names))
+	for _, name := range names {
+		if e, ok := h.Parasite(name); ok {
+			metrics[name] = e.Metrics()
+		}
+	}
+
+	b := &strings.Builder{}
+	metric := func(name string, help string, typ string, value func(m *Metrics) string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, parasite := range names {
+			if m, ok := metrics[parasite]; ok {
+				fmt.Fprintf(b, "%s{parasite=\"%s\"} %s\n", name, escapeLabel(parasite), value(m))
+			}
+		}
+	}
+	uintValue := func(get func(m *Metrics) uint64) func(m *Metrics) string {
+		return func(m *Metrics) string { return fmt.Sprint(get(m)) }
+	}
+	metric("plugin_parasite_calls_total", "Calls made to the parasite.", "counter",
+		uintValue(func(m *Metrics) uint64 { return m.Calls }))
+	metric("plugin_parasite_errors_total", "Calls to the parasite that failed.", "counter",
+		uintValue(func(m *Metrics) uint64 { return m.Errors }))
+	metric("plugin_parasite_timeouts_total", "Calls to the parasite that timed out.", "counter",
+		uintValue(func(m *Metrics) uint64 { return m.Timeouts }))
+	metric("plugin_parasite_in_flight", "Calls to the parasite waiting for a reply.", "gauge",
+		func(m *Metrics) string { return fmt.Sprint(m.InFlight) })
+	metric("plugin_parasite_sent_bytes_total", "Bytes of the requests sent to the parasite.", "counter",
+		uintValue(func(m *Metrics) uint64 { return m.BytesOut }))
+	metric("plugin_parasite_received_bytes_total", "Bytes of the replies received from the parasite.", "counter",
+		uintValue(func(m *Metrics) uint64 { return m.BytesIn }))
+	metric("plugin_parasite_restarts_total", "Restarts of the parasite.", "counter",
+		uintValue(func(m *Metrics) uint64 { return m.Restarts }))
+	metric("plugin_parasite_healthy", "Whether the parasite answers the health checks.", "gauge",
+		func(m *Metrics) string {
+			if m.Healthy {
+				return "1"
+			}
+			return "0"
+		})
+
+	name := "plugin_parasite_call_duration_seconds"
+	fmt.Fprintf(b, "# HELP %s Latency of the calls to the parasite.\n# TYPE %s histogram\n", name, name)
+	for _, parasite := range names {
+		m, ok := metrics[parasite]
+		if !ok {
+			continue
+		}
+		label := escapeLabel(parasite)
+		cumulative := uint64(0)
+		for i, bound := range LatencyBuckets {
+			cumulative += m.LatencyCounts[i]
+			fmt.Fprintf(b, "%s_bucket{parasite=\"%s\",le=\"%g\"} %d\n", name, label, bound.Seconds(), cumulative)
+		}
+		cumulative += m.LatencyCounts[len(LatencyBuckets)]
+		fmt.Fprintf(b, "%s_bucket{parasite=\"%s\",le=\"+Inf\"} %d\n", name, label, cumulative)
+		fmt.Fprintf(b, "%s_sum{parasite=\"%s\"} %g\n", name, label, m.LatencySum.Seconds())
+		fmt.Fprintf(b, "%s_count{parasite=\"%s\"} %d\n", name, label, cumulative)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MetricsHandler serves the metrics of WritePrometheus over HTTP.
+func (h *Host) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = h.WritePrometheus(w)
+	})
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
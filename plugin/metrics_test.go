@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echo struct{}
+
+func (echo) Init() error { return nil }
+
+func (echo) UnInit() {}
+
+func (echo) Handle(data []byte) ([]byte, error) {
+	if string(data) == "fail" {
+		return nil, errors.New("fail")
+	}
+	return data, nil
+}
+
+func init() {
+	RegisterHandler("echo", echo{})
+}
+
+// loadTestParasite serves the handlers registered in the test process to h.
+func loadTestParasite(t *testing.T, h *Host) *Entity {
+	t.Helper()
+	return loadTestParasiteWithOptions(t, h, &Options{})
+}
+
+// loadTestParasiteWithOptions is loadTestParasite with options, whose names
+// and versions are filled.
+func loadTestParasiteWithOptions(t *testing.T, h *Host, options *Options) *Entity {
+	t.Helper()
+	options.Name = "test"
+	options.Version = "1.0.0"
+	options.HostName = h.name
+	options.HostMinimalVersion = h.version
+	err := h.LoadFunc("test", func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error {
+		return ServeParasite(ctx, r, w, handshake, options)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	e, _ := h.Parasite("test")
+	return e
+}
+
+func TestEntity_Metrics(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	e := loadTestParasite(t, h)
+
+	if _, err := e.CallWithResponse("echo", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CallWithResponse("echo", []byte("fail")); err == nil {
+		t.Fatal("the call should fail")
+	}
+	m := e.Metrics()
+	if m.Calls != 2 || m.Errors != 1 || m.BytesOut != 9 || m.BytesIn != 5 || m.InFlight != 0 || !m.Healthy {
+		t.Errorf("unexpected metrics %+v", m)
+	}
+	count := uint64(0)
+	for _, c := range m.LatencyCounts {
+		count += c
+	}
+	if count != 2 {
+		t.Errorf("every call should be in the histogram, got %d", count)
+	}
+
+	b := &strings.Builder{}
+	if err := h.WritePrometheus(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`plugin_parasite_calls_total{parasite="test"} 2`,
+		`plugin_parasite_errors_total{parasite="test"} 1`,
+		`plugin_parasite_call_duration_seconds_bucket{parasite="test",le="+Inf"} 2`,
+		`plugin_parasite_call_duration_seconds_count{parasite="test"} 2`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("%q is missing from\n%s", line, b)
+		}
+	}
+}
+
+func TestEntity_checkHealth(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{
+		Name:                "host",
+		Version:             "1.0.0",
+		HealthCheckInterval: 10 * time.Millisecond,
+		UnhealthyThreshold:  2,
+	})
+	events := make(chan EventType, 10)
+	h.Subscribe(func(event *Event) {
+		if event.Type == EventUnhealthy || event.Type == EventHealthy {
+			events <- event.Type
+		}
+	})
+	e := loadTestParasite(t, h)
+
+	if err := e.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// a parasite that does not answer anymore
+	e.procLocker.RLock()
+	proc := e.proc
+	e.procLocker.RUnlock()
+	proc.conn.writeLocker.Lock()
+	writer := proc.conn.writer
+	proc.conn.writer = io.Discard
+	proc.conn.writeLocker.Unlock()
+	select {
+	case event := <-events:
+		if event != EventUnhealthy || e.Healthy() {
+			t.Errorf("the parasite should be unhealthy, got %s", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the parasite should be marked unhealthy")
+	}
+
+	proc.conn.writeLocker.Lock()
+	proc.conn.writer = writer
+	proc.conn.writeLocker.Unlock()
+	select {
+	case event := <-events:
+		if event != EventHealthy || !e.Healthy() {
+			t.Errorf("the parasite should be healthy again, got %s", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the parasite should be marked healthy again")
+	}
+}
@@ -1,5 +1,7 @@
 package plugin
 
+import "context"
+
 type Handler func(name string, data []byte) ([]byte, error)
 
 type Parasite interface {
@@ -8,6 +10,62 @@ type Parasite interface {
 	Handle(data []byte) ([]byte, error)
 }
 
+// ContextParasite is implemented by parasites whose handler can abort once the
+// host cancels the call or its deadline passes, or that use the metadata of
+// the call, see MetadataFromContext. HandleContext is used instead of Handle
+// when available.
+type ContextParasite interface {
+	Parasite
+	HandleContext(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// ParasiteV2 serves one or more calls, registered with RegisterParasite.
+// Init is called once before the parasite tells the host it is ready, and an
+// error fails the start of the parasite. Handle gets the name of the call it
+// handles. Shutdown is called once the calls are drained, its ctx is canceled
+// when the parasite is told to abort them.
+type ParasiteV2 interface {
+	Init(ctx context.Context) error
+	Handle(ctx context.Context, call string, data []byte) ([]byte, error)
+	Shutdown(ctx context.Context) error
+}
+
+// parasiteAdapter serves a Parasite as a ParasiteV2.
+type parasiteAdapter struct {
+	parasite Parasite
+}
+
+// AdaptParasite returns a ParasiteV2 serving its calls with parasite, as
+// RegisterHandler does: UnInit is run on Shutdown and HandleContext is used
+// for a ContextParasite.
+func AdaptParasite(parasite Parasite) ParasiteV2 {
+	return &parasiteAdapter{parasite: parasite}
+}
+
+func (a *parasiteAdapter) Init(ctx context.Context) error {
+	return a.parasite.Init()
+}
+
+func (a *parasiteAdapter) Handle(ctx context.Context, call string, data []byte) ([]byte, error) {
+	if p, ok := a.parasite.(ContextParasite); ok {
+		return p.HandleContext(ctx, data)
+	}
+	return a.parasite.Handle(data)
+}
+
+func (a *parasiteAdapter) Shutdown(ctx context.Context) error {
+	a.parasite.UnInit()
+	return nil
+}
+
 type Executor interface {
 	OnCall(call string, data []byte) ([]byte, error)
 }
+
+// ContextExecutor is implemented by executors that read the metadata of the
+// calls with MetadataFromContext or set the one of their replies with
+// SetReplyMetadata. OnCallContext is used instead of OnCall when available.
+type ContextExecutor interface {
+	Executor
+	OnCallContext(ctx context.Context, call string, data []byte) ([]byte, error)
+}
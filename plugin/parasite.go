@@ -1,16 +1,23 @@
 package plugin
 
 import (
-	"bufio"
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/delichik/daf/logger"
+	"github.com/vmihailenco/msgpack"
 	"go.uber.org/zap"
 )
 
@@ -19,15 +26,168 @@ type Options struct {
 	Version            string
 	HostName           string
 	HostMinimalVersion string
+	// HostVersionConstraint is a VersionConstraint the version of the host
+	// must satisfy, such as ">=1.2 <2", on top of HostMinimalVersion.
+	HostVersionConstraint string
+	// Secret is the key shared with a host that authenticates its parasites.
+	Secret []byte
+	// PrivateKey is used instead of Secret to prove the parasite holds a key
+	// the host trusts.
+	PrivateKey ed25519.PrivateKey
+	// Workers is how many calls are handled at the same time, 1 if it is not
+	// set so that calls are handled one after another.
+	Workers int
+	// HandlerLimits caps how many calls of the named handlers are handled at
+	// the same time, within the Workers.
+	HandlerLimits map[string]int
+	// QueueSize is how many calls may wait for a worker before the parasite
+	// fails the new ones with ErrParasiteBusy, 1024 if it is not set. The
+	// replies to the calls to the host are read meanwhile.
+	QueueSize int
+	// IncomingInterceptors run around the handlers, after RecoveryInterceptor,
+	// and OutgoingInterceptors around the calls to the host. The first one is
+	// the outermost.
+	IncomingInterceptors []Interceptor
+	OutgoingInterceptors []Interceptor
 }
 
-var registeredParasites = make(map[string]Parasite)
+const defaultQueueSize = 1024
+
+// registry holds the handlers a parasite serves by call name.
+type registry struct {
+	parasites map[string]ParasiteV2
+	// instances are the parasites in the order they were registered, each
+	// one is initialized once whatever the number of its calls
+	instances []*registeredParasite
+	streams   map[string]StreamHandler
+}
+
+type registeredParasite struct {
+	parasite ParasiteV2
+	calls    []string
+}
+
+func newRegistry() *registry {
+	return &registry{
+		parasites: make(map[string]ParasiteV2),
+		streams:   make(map[string]StreamHandler),
+	}
+}
+
+// register makes parasite serve calls, in place of the parasites registered
+// for them before.
+func (r *registry) register(parasite ParasiteV2, calls []string) {
+	calls = append([]string(nil), calls...)
+	instances := r.instances[:0]
+	for _, instance := range r.instances {
+		kept := instance.calls[:0]
+		for _, call := range instance.calls {
+			if !slices.Contains(calls, call) {
+				kept = append(kept, call)
+			}
+		}
+		instance.calls = kept
+		if len(kept) > 0 {
+			instances = append(instances, instance)
+		}
+	}
+	r.instances = append(instances, &registeredParasite{parasite: parasite, calls: calls})
+	for _, call := range calls {
+		r.parasites[call] = parasite
+	}
+}
+
+// init runs Init on the parasites in the order they were registered until
+// one fails, and returns the ones initialized.
+func (r *registry) init(ctx context.Context) ([]*registeredParasite, error) {
+	for i, instance := range r.instances {
+		name := strings.Join(instance.calls, ", ")
+		logger.Info("parasite is starting", zap.String("calls", name))
+		if err := instance.parasite.Init(ctx); err != nil {
+			return r.instances[:i], fmt.Errorf("init %s: %w", name, err)
+		}
+		logger.Info("parasite is started", zap.String("calls", name))
+	}
+	return r.instances, nil
+}
+
+// shutdownParasites runs Shutdown on parasites in the reverse order, with a
+// context canceled once abort is closed.
+func shutdownParasites(parasites []*registeredParasite, abort <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if abort != nil {
+		go func() {
+			select {
+			case <-abort:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	for i := len(parasites) - 1; i >= 0; i-- {
+		if err := parasites[i].parasite.Shutdown(ctx); err != nil {
+			logger.Error("parasite shutdown failed",
+				zap.Strings("calls", parasites[i].calls), zap.Error(err))
+		}
+	}
+}
+
+// calls returns the sorted names of all handlers.
+func (r *registry) calls() []string {
+	calls := make([]string, 0, len(r.parasites)+len(r.streams))
+	for name := range r.parasites {
+		calls = append(calls, name)
+	}
+	for name := range r.streams {
+		calls = append(calls, name)
+	}
+	sort.Strings(calls)
+	return calls
+}
+
+var defaultRegistry = newRegistry()
+
+// parasiteConn is the pipe to the host, the one the host passed in
+// envRPCFDs or the standard input and output.
+var parasiteConn = newParasiteConn()
+
+func newParasiteConn() *conn {
+	if _, ok := os.LookupEnv(envSandboxLimits); ok {
+		// the host executable run in place of a parasite leaves the pipe to
+		// the parasite
+		return newConn(os.Stdin, os.Stdout)
+	}
+	fds := strings.Split(os.Getenv(envRPCFDs), ",")
+	if len(fds) == 2 {
+		in, inErr := strconv.Atoi(fds[0])
+		out, outErr := strconv.Atoi(fds[1])
+		if inErr == nil && outErr == nil {
+			// children of the parasite must not write to the pipe
+			_ = os.Unsetenv(envRPCFDs)
+			return newConn(os.NewFile(uintptr(in), "rpc-in"), os.NewFile(uintptr(out), "rpc-out"))
+		}
+	}
+	return newConn(os.Stdin, os.Stdout)
+}
 
 func RegisterHandler(name string, parasite Parasite) {
-	registeredParasites[name] = parasite
+	defaultRegistry.register(&parasiteAdapter{parasite: parasite}, []string{name})
 }
 
-func RunParasite(options *Options) {
+// RegisterParasite registers parasite as the handler of calls, replacing the
+// handlers registered for them before.
+func RegisterParasite(parasite ParasiteV2, calls ...string) {
+	defaultRegistry.register(parasite, calls)
+}
+
+// RegisterStreamHandler registers handler for the streams the host opens for
+// the call name with Entity.OpenStream.
+func RegisterStreamHandler(name string, handler StreamHandler) {
+	defaultRegistry.streams[name] = handler
+}
+
+func checkOptions(options *Options) {
 	if options.Name == "" {
 		panic("parasite name is required")
 	}
@@ -40,85 +200,494 @@ func RunParasite(options *Options) {
 		panic("parasite host name is required")
 	}
 
-	if options.HostMinimalVersion == "" {
-		panic("parasite host minimal version is required")
+	if options.HostMinimalVersion == "" && options.HostVersionConstraint == "" {
+		panic("parasite host minimal version or version constraint is required")
 	}
+}
+
+// RunParasite serves the registered handlers to the host that started the
+// parasite, or with -connect to a host serving parasites with Host.Serve,
+// such as "-connect unix:///run/host.sock" or "-connect tcp://127.0.0.1:7000",
+// which lets a parasite run in another container or under a debugger.
+func RunParasite(options *Options) {
+	checkOptions(options)
 
 	handshake := ""
+	connect := ""
 	flag.StringVar(&handshake, "h", "", "")
+	flag.StringVar(&connect, "connect", "", "address of the host to connect to")
 	flag.Parse()
-	if !checkHandshake(handshake, options) {
-		fmt.Printf("This executable binary is a parasite for %s %s+, Do not run it alone\n",
-			options.HostName, options.HostMinimalVersion)
+	if connect != "" {
+		network, address, err := parseConnect(connect)
+		if err == nil {
+			ctx, cancel := signal.NotifyContext(context.Background(),
+				os.Interrupt, syscall.SIGABRT, syscall.SIGTERM, syscall.SIGQUIT)
+			err = DialHost(ctx, network, address, options)
+			cancel()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	hostInfo, err := checkHandshake(handshake, options)
+	if errors.Is(err, errNoHandshake) {
+		required := options.HostVersionConstraint
+		if options.HostMinimalVersion != "" {
+			required = strings.TrimSpace(">=" + options.HostMinimalVersion + " " + required)
+		}
+		fmt.Printf("This executable binary is a parasite for %s %s, Do not run it alone\n",
+			options.HostName, required)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		refuseHost(parasiteConn, err)
 		os.Exit(1)
 	}
 
-	for name, parasite := range registeredParasites {
-		fmt.Printf("Parasite %s is starting...\n", name)
-		parasite.Init()
-		fmt.Printf("Parasite %s is started\n", name)
+	// the first signal drains the calls, a second one aborts them
+	stop := make(chan struct{})
+	abort := make(chan struct{})
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, syscall.SIGABRT, syscall.SIGTERM, syscall.SIGQUIT)
+	go func() {
+		<-signalChan
+		close(stop)
+		<-signalChan
+		close(abort)
+	}()
+	err = serveParasite(parasiteConn, defaultHostClient, hostInfo, options, defaultRegistry, stop, abort)
+	signal.Stop(signalChan)
+	if err != nil {
+		os.Exit(1)
 	}
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
+// ServeParasite runs the handlers registered in this process as the parasite
+// of a host reading from r and writing to w, as RunParasite does over the
+// pipes of a parasite process, until ctx is done or the host asks it to shut
+// down. handshake is what the host passes with -h. CallHost and
+// CallHostWithResponse reach this host while it is served, so only one
+// parasite should be served at a time. It is meant for tests and parasites
+// running in the host process with Host.LoadFunc, which must not call
+// InitParasiteLogger since they share the logger of the host.
+func ServeParasite(ctx context.Context, r io.Reader, w io.Writer, handshake string, options *Options) error {
+	checkOptions(options)
+	conn := newConn(r, w)
+	hostInfo, err := checkHandshake(handshake, options)
+	if err != nil {
+		if !errors.Is(err, errNoHandshake) {
+			refuseHost(conn, err)
+		}
+		return err
+	}
+
+	client := &HostClient{conn: conn}
+	previous := hostClient.Swap(client)
+	defer hostClient.CompareAndSwap(client, previous)
+	return serveParasite(conn, client, hostInfo, options, defaultRegistry, ctx.Done(), nil)
+}
+
+// refuseHost tells the host why the parasite refuses it instead of just
+// exiting.
+func refuseHost(conn *conn, err error) {
+	_ = conn.send(&sendObject{
+		call:      callHandshake,
+		remoteErr: toRemoteError(err),
+	})
+}
+
+// serveParasite answers the handshake of the host, runs Init and handles the
+// calls with handlers until stop is closed or the host asks the parasite to shut down. It
+// then waits for the pending calls, or for abort to be closed, and runs
+// Shutdown. A failing Init is reported to the host, which fails the start of
+// the parasite, and returned.
+func serveParasite(conn *conn, client *HostClient, hostInfo *HandshakeInfo, options *Options,
+	handlers *registry, stop <-chan struct{}, abort <-chan struct{}) error {
+	framingName := pickFraming(hostInfo.Framings)
+	codec := pickCodec(hostInfo.Codecs)
+	handshakeData, err := msgpack.Marshal(&HandshakeInfo{
+		Name:     options.Name,
+		Version:  options.Version,
+		Calls:    handlers.calls(),
+		Framings: []string{framingName},
+		Codecs:   []string{codec.Name()},
+		Proof:    newProof(hostInfo.Nonce, options),
+		Metadata: hostInfo.Metadata,
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = conn.send(&sendObject{
+		call:    callHandshake,
+		content: handshakeData,
+	})
+	if err != nil {
+		return err
+	}
+	f, _ := framingByName(framingName)
+	conn.setReadFraming(f)
+	conn.setWriteFraming(f)
+	if hostInfo.Metadata {
+		conn.enableMetadata()
+	}
+
+	client.parasite = options.Name
+	if len(options.OutgoingInterceptors) > 0 {
+		client.interceptor = ChainInterceptors(options.OutgoingInterceptors...)
+	}
+	s := newServer(conn, client, handlers, options, codec)
+	s.start()
+
+	// the host is told once Init is done, calls to the host can already be
+	// made from Init
+	initCtx, cancelInit := context.WithCancel(s.ctx)
 	go func() {
-		buf := bufio.NewReader(os.Stdin)
-		for {
-			req, err := read(buf)
-			if err != nil {
-				return
-			}
-			if ctx.Err() != nil {
-				return
+		select {
+		case <-stop:
+		case <-s.shutdown:
+		case <-initCtx.Done():
+		}
+		cancelInit()
+	}()
+	initialized, initErr := handlers.init(initCtx)
+	cancelInit()
+	ready := &sendObject{
+		call: callReady,
+	}
+	if initErr != nil {
+		logger.Error("parasite init failed", zap.Error(initErr))
+		ready.remoteErr = toRemoteError(initErr)
+	}
+	err = conn.send(ready)
+	if initErr != nil {
+		s.cancel()
+		shutdownParasites(initialized, abort)
+		return initErr
+	}
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-stop:
+	case <-s.shutdown:
+	}
+	s.drain(abort)
+
+	shutdownParasites(initialized, abort)
+	return nil
+}
+
+type parasiteRequest struct {
+	ctx   context.Context
+	frame *sendObject
+}
+
+// server handles the calls the host sends over conn, and the replies to the
+// calls made by client.
+type server struct {
+	conn     *conn
+	client   *HostClient
+	handlers *registry
+	streams  streamSet
+	// name is the name of the parasite and interceptor runs around the
+	// handlers
+	name        string
+	interceptor Interceptor
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// queued bounds the calls read from the host and not handled yet,
+	// workers the calls being handled and handlerSlots the calls being
+	// handled by a handler with a limit
+	queued       chan struct{}
+	workers      chan struct{}
+	handlerSlots map[string]chan struct{}
+
+	inflight       map[uint64]context.CancelFunc
+	inflightLocker sync.Mutex
+	// pending counts the calls queued or being handled, no call is added
+	// once draining is set
+	pending  sync.WaitGroup
+	draining bool
+
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+func newServer(conn *conn, client *HostClient, handlers *registry, options *Options, codec Codec) *server {
+	ctx, cancel := context.WithCancel(withHostClient(withCodec(context.Background(), codec), client))
+	workers := options.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	queueSize := options.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	handlerSlots := make(map[string]chan struct{}, len(options.HandlerLimits))
+	for name, limit := range options.HandlerLimits {
+		if limit > 0 {
+			handlerSlots[name] = make(chan struct{}, limit)
+		}
+	}
+	return &server{
+		conn:         conn,
+		client:       client,
+		handlers:     handlers,
+		name:         options.Name,
+		interceptor:  ChainInterceptors(append([]Interceptor{RecoveryInterceptor}, options.IncomingInterceptors...)...),
+		ctx:          ctx,
+		cancel:       cancel,
+		queued:       make(chan struct{}, queueSize+workers),
+		workers:      make(chan struct{}, workers),
+		handlerSlots: handlerSlots,
+		inflight:     map[uint64]context.CancelFunc{},
+		shutdown:     make(chan struct{}),
+	}
+}
+
+func (s *server) start() {
+	go s.readLoop()
+}
+
+// requestShutdown is called when the host asks the parasite to exit or the
+// pipe to the host is closed.
+func (s *server) requestShutdown() {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+	})
+}
+
+// drain stops accepting calls and waits for the pending ones to be handled,
+// or for abort to receive.
+func (s *server) drain(abort <-chan struct{}) {
+	s.inflightLocker.Lock()
+	s.draining = true
+	s.inflightLocker.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-abort:
+	}
+	s.cancel()
+}
+
+func (s *server) readLoop() {
+	for {
+		req, err := s.conn.read()
+		if err != nil {
+			s.client.pending.failAll(ErrHostClosed)
+			s.streams.abortAll(ErrHostClosed)
+			s.requestShutdown()
+			return
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		if strings.HasSuffix(req.call, callReply) {
+			s.client.pending.resolve(req)
+			continue
+		}
+		if isStreamFrame(req.call) {
+			if stream, ok := s.streams.get(req.id); ok {
+				stream.deliver(req)
 			}
-			parasite, ok := registeredParasites[req.call]
-			if !ok {
-				send(os.Stdout, &sendObject{
-					id:      req.id,
-					call:    req.call + callReply,
-					content: []byte(""),
-				})
-				continue
+			continue
+		}
+		switch req.call {
+		case callCancel:
+			s.inflightLocker.Lock()
+			if cancelCall, ok := s.inflight[req.id]; ok {
+				cancelCall()
 			}
-			rsp, err := parasite.Handle(req.content)
-			if err != nil {
-				logger.Error("parasite handle failed", zap.String("call", req.call), zap.Error(err))
-				send(os.Stdout, &sendObject{
-					id:      req.id,
-					call:    req.call + callReply,
-					content: []byte(err.Error()),
-				})
-				continue
+			s.inflightLocker.Unlock()
+			if stream, ok := s.streams.get(req.id); ok {
+				stream.cancel()
 			}
-			send(os.Stdout, &sendObject{
-				id:      req.id,
-				call:    req.call + callReply,
-				content: rsp,
+			continue
+		case callShutdown:
+			s.requestShutdown()
+			continue
+		case callPing:
+			// answered by the reading goroutine, even when every worker is
+			// busy
+			_ = s.conn.send(&sendObject{
+				id:   req.id,
+				call: callPing + callReply,
+			})
+			continue
+		case callStreamOpen:
+			s.openStream(req)
+			continue
+		}
+
+		s.inflightLocker.Lock()
+		if s.draining {
+			s.inflightLocker.Unlock()
+			_ = s.conn.send(&sendObject{
+				id:        req.id,
+				call:      req.call + callReply,
+				remoteErr: toRemoteError(ErrShuttingDown),
 			})
+			continue
+		}
+		select {
+		case s.queued <- struct{}{}:
+		default:
+			// the reading goes on while the queue is full, the handlers may
+			// be waiting for the replies of the host
+			s.inflightLocker.Unlock()
+			_ = s.conn.send(&sendObject{
+				id:        req.id,
+				call:      req.call + callReply,
+				remoteErr: toRemoteError(ErrParasiteBusy),
+			})
+			continue
+		}
+		callCtx, cancelCall := context.WithCancel(s.ctx)
+		s.inflight[req.id] = cancelCall
+		s.pending.Add(1)
+		s.inflightLocker.Unlock()
+
+		go s.dispatch(&parasiteRequest{ctx: callCtx, frame: req})
+	}
+}
+
+// openStream runs the stream handler on its own goroutine, streams are not
+// bound by the workers as they may last as long as the parasite.
+func (s *server) openStream(req *sendObject) {
+	name := string(req.content)
+	end := &sendObject{
+		id:   req.id,
+		call: callStreamEnd,
+	}
+	handler, ok := s.handlers.streams[name]
+	if !ok {
+		end.remoteErr = toRemoteError(fmt.Errorf("%w: %s", ErrUnknownCall, name))
+		_ = s.conn.send(end)
+		return
+	}
+
+	s.inflightLocker.Lock()
+	if s.draining {
+		s.inflightLocker.Unlock()
+		end.remoteErr = toRemoteError(ErrShuttingDown)
+		_ = s.conn.send(end)
+		return
+	}
+	s.pending.Add(1)
+	s.inflightLocker.Unlock()
+
+	stream := newStream(s.ctx, req.id, name, s.conn, false)
+	s.streams.add(stream)
+	go func() {
+		defer s.pending.Done()
+		err := handler(stream)
+		if err != nil {
+			logger.Error("parasite stream handle failed", zap.String("call", name), zap.Error(err))
+		}
+		stream.sendClosed.Store(true)
+		stream.finish()
+		s.streams.remove(stream.id)
+		if stream.ctx.Err() == nil {
+			end.remoteErr = toRemoteError(err)
+			_ = s.conn.send(end)
 		}
+		stream.cancel()
 	}()
+}
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGABRT, syscall.SIGTERM, syscall.SIGQUIT)
-	<-signalChan
-	cancel()
-	signal.Stop(signalChan)
+// dispatch waits for a slot of the handler, then for a worker, and handles
+// req. The reply carries the id of req so replies may be sent in any order.
+func (s *server) dispatch(req *parasiteRequest) {
+	defer func() {
+		s.inflightLocker.Lock()
+		s.inflight[req.frame.id]()
+		delete(s.inflight, req.frame.id)
+		s.inflightLocker.Unlock()
+		<-s.queued
+		s.pending.Done()
+	}()
 
-	for _, parasite := range registeredParasites {
-		parasite.UnInit()
+	if slots, ok := s.handlerSlots[req.frame.call]; ok {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-req.ctx.Done():
+			return
+		}
 	}
+	select {
+	case s.workers <- struct{}{}:
+		defer func() { <-s.workers }()
+	case <-req.ctx.Done():
+		return
+	}
+	s.handle(req)
 }
 
+func (s *server) handle(req *parasiteRequest) {
+	if req.ctx.Err() != nil {
+		// the host has given up on the call before it was handled
+		return
+	}
+	reply := &sendObject{
+		id:   req.frame.id,
+		call: req.frame.call + callReply,
+	}
+	parasite, ok := s.handlers.parasites[req.frame.call]
+	if !ok {
+		reply.remoteErr = toRemoteError(fmt.Errorf("%w: %s", ErrUnknownCall, req.frame.call))
+		s.conn.send(reply)
+		return
+	}
+	ctx, cancel, replyMD := handlerContext(req.ctx, req.frame.metadata)
+	defer cancel()
+	info := &CallInfo{Parasite: s.name, Call: req.frame.call}
+	rsp, err := s.interceptor(ctx, info, req.frame.content, func(ctx context.Context, data []byte) ([]byte, error) {
+		return parasite.Handle(ctx, req.frame.call, data)
+	})
+	if req.ctx.Err() != nil {
+		return
+	}
+	reply.metadata = replyMD.get()
+	if err != nil {
+		logger.Error("parasite handle failed", zap.String("call", req.frame.call), zap.Error(err))
+		reply.remoteErr = toRemoteError(err)
+		s.conn.send(reply)
+		return
+	}
+	reply.content = rsp
+	s.conn.send(reply)
+}
+
+// logWriter sends log entries to the host of client, the host the parasite
+// is served to if it is nil.
 type logWriter struct {
-	writer io.Writer
+	client *HostClient
 }
 
 func (w *logWriter) Write(data []byte) (n int, err error) {
+	client := w.client
+	if client == nil {
+		client = hostClient.Load()
+	}
 	req := &sendObject{
 		id:      0,
 		call:    callLogger,
 		content: data,
 	}
-	err = send(w.writer, req)
+	err = client.conn.send(req)
 	return len(data), err
 }
 
@@ -130,6 +699,20 @@ func InitParasiteLogger() {
 	logger.InitDefaultManual(&logger.Config{
 		Level:     "debug",
 		Format:    "json",
-		LogDriver: &logWriter{writer: os.Stdout},
+		LogDriver: LogWriter(),
 	})
 }
+
+// LogWriter returns the writer InitParasiteLogger logs to, which forwards the
+// JSON entries of a zap logger to the host. Parasites served in the host
+// process may log to the host with a logger of their own writing to it, or
+// to HostClient.LogWriter for parasites loaded with Host.LoadParasite.
+func LogWriter() io.Writer {
+	return &logWriter{}
+}
+
+// LogWriter returns a writer forwarding the JSON entries of a zap logger to
+// the host of c, see the LogWriter function.
+func (c *HostClient) LogWriter() io.Writer {
+	return &logWriter{client: c}
+}
@@ -0,0 +1,273 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type multiParasite struct {
+	initErr   error
+	inits     int
+	shutdowns int
+	locker    sync.Mutex
+}
+
+func (p *multiParasite) Init(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.inits++
+	return p.initErr
+}
+
+func (p *multiParasite) Handle(ctx context.Context, call string, data []byte) ([]byte, error) {
+	return []byte(call), nil
+}
+
+func (p *multiParasite) Shutdown(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.shutdowns++
+	return nil
+}
+
+// useRegistry serves the handlers of r instead of the registered ones until
+// the test ends.
+func useRegistry(t *testing.T, r *registry) {
+	previous := defaultRegistry
+	defaultRegistry = r
+	t.Cleanup(func() {
+		defaultRegistry = previous
+	})
+}
+
+func TestRegistry_register(t *testing.T) {
+	r := newRegistry()
+	first := &multiParasite{}
+	r.register(first, []string{"a", "b"})
+	r.register(&parasiteAdapter{parasite: echo{}}, []string{"b"})
+	if len(r.instances) != 2 || strings.Join(r.instances[0].calls, ",") != "a" || r.parasites["a"] != first {
+		t.Fatalf("b should move to the new parasite, got %+v", r.instances[0])
+	}
+	r.register(&multiParasite{}, []string{"a"})
+	if len(r.instances) != 2 || r.instances[0].calls[0] != "b" {
+		t.Errorf("the first parasite should be dropped once it serves no call")
+	}
+	if strings.Join(r.calls(), ",") != "a,b" {
+		t.Errorf("unexpected calls %v", r.calls())
+	}
+}
+
+func TestParasiteV2(t *testing.T) {
+	r := newRegistry()
+	p := &multiParasite{}
+	r.register(p, []string{"x", "y"})
+	r.register(&parasiteAdapter{parasite: echo{}}, []string{"echo"})
+	useRegistry(t, r)
+
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	e := loadTestParasite(t, h)
+	for _, call := range []string{"x", "y"} {
+		rsp, err := e.CallWithResponse(call, nil)
+		if err != nil || string(rsp) != call {
+			t.Errorf("%s: unexpected reply %q %v", call, rsp, err)
+		}
+	}
+	if rsp, err := e.CallWithResponse("echo", []byte("hello")); err != nil || string(rsp) != "hello" {
+		t.Errorf("a Parasite should be served as a ParasiteV2, got %q %v", rsp, err)
+	}
+	if err := h.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p.inits != 1 || p.shutdowns != 1 {
+		t.Errorf("Init and Shutdown should run once, got %d and %d", p.inits, p.shutdowns)
+	}
+}
+
+func TestParasiteV2_initFailed(t *testing.T) {
+	r := newRegistry()
+	first := &multiParasite{}
+	r.register(first, []string{"x"})
+	r.register(&multiParasite{initErr: errors.New("no database")}, []string{"y"})
+	useRegistry(t, r)
+
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	events := make(chan *Event, 1)
+	h.Subscribe(func(event *Event) {
+		if event.Type == EventFailed {
+			events <- event
+		}
+	})
+	err := h.LoadFunc("test", func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error {
+		return ServeParasite(ctx, r, w, handshake, &Options{
+			Name:               "test",
+			Version:            "1.0.0",
+			HostName:           "host",
+			HostMinimalVersion: "1.0.0",
+		})
+	})
+	if !errors.Is(err, ErrParasiteInit) || !strings.Contains(err.Error(), "no database") {
+		t.Fatalf("the start should fail with the error of Init, got %v", err)
+	}
+	if event := <-events; !errors.Is(event.Err, ErrParasiteInit) {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if _, ok := h.Parasite("test"); ok {
+		t.Error("the parasite should not be loaded")
+	}
+}
+
+func init() {
+	testParasites["gated"] = func(options *Options) {
+		options.Workers = 2
+		options.HandlerLimits = map[string]int{"limited": 1}
+		RegisterHandler("limited", gated("limited"))
+		RegisterHandler("free", gated("free"))
+	}
+}
+
+// gated records the calls it starts handling and holds them until the test
+// creates the gate file named by their data.
+func gated(call string) handlerFunc {
+	return func(data []byte) ([]byte, error) {
+		recordEvent(call + "/" + string(data))
+		gate := filepath.Join(os.Getenv(envTestDir), string(data))
+		for {
+			if _, err := os.Stat(gate); err == nil {
+				return data, nil
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestServer_workers(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	dir := loadTestParasites(t, h, "gated")
+	e, ok := h.Parasite("gated")
+	if !ok {
+		t.Fatal("the parasite should be loaded")
+	}
+
+	replies := map[string]chan string{}
+	call := func(call string, data string) {
+		replies[data] = make(chan string, 1)
+		go func(reply chan<- string) {
+			rsp, err := e.CallWithResponse(call, []byte(data))
+			if err != nil {
+				t.Errorf("%s: %v", data, err)
+			}
+			reply <- string(rsp)
+		}(replies[data])
+	}
+	expectStarted := func(started string) {
+		t.Helper()
+		waitFor(t, started+" to start", func() bool {
+			return countEvents(dir, started) == 1
+		})
+	}
+	open := func(gate string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, gate), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call("limited", "a")
+	expectStarted("limited/a")
+	call("limited", "b")
+	time.Sleep(50 * time.Millisecond)
+	if countEvents(dir, "limited/b") != 0 {
+		t.Fatal("the handler limit should hold b back")
+	}
+	// the call held back by its handler limit does not take a worker
+	call("free", "c")
+	expectStarted("free/c")
+
+	open("c")
+	if rsp := <-replies["c"]; rsp != "c" {
+		t.Errorf("the reply should match its call, got %q", rsp)
+	}
+	select {
+	case rsp := <-replies["a"]:
+		t.Fatalf("the call still being handled should not get a reply, got %q", rsp)
+	default:
+	}
+	open("a")
+	expectStarted("limited/b")
+	open("b")
+	for _, data := range []string{"a", "b"} {
+		if rsp := <-replies[data]; rsp != data {
+			t.Errorf("the reply should match its call, got %q for %q", rsp, data)
+		}
+	}
+}
+
+// hostCaller asks the host for the reply of each call.
+type hostCaller struct{}
+
+func (hostCaller) Init(ctx context.Context) error {
+	return nil
+}
+
+func (hostCaller) Handle(ctx context.Context, call string, data []byte) ([]byte, error) {
+	return CallHostWithResponse(ctx, call, data)
+}
+
+func (hostCaller) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestServer_queueFull(t *testing.T) {
+	called := make(chan struct{}, 4)
+	release := make(chan struct{})
+	h := NewHostWithOptions(&HostOptions{
+		Name:    "host",
+		Version: "1.0.0",
+		Executor: executorFunc(func(call string, data []byte) ([]byte, error) {
+			called <- struct{}{}
+			<-release
+			return data, nil
+		}),
+	})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	err := h.LoadParasite(&Options{Name: "busy", Workers: 1, QueueSize: 1}, hostCaller{}, "ask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := h.Parasite("busy")
+
+	errs := make(chan error, 4)
+	call := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := e.CallWithResponseContext(ctx, "ask", []byte("data"))
+		errs <- err
+	}
+	go call()
+	<-called
+
+	// the worker waits for the host, one more call fits in the queue
+	for i := 0; i < 3; i++ {
+		go call()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, ErrParasiteBusy) {
+			t.Errorf("the call should fail with ErrParasiteBusy, got %v", err)
+		}
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("the queued calls should be handled, got %v", err)
+		}
+	}
+}
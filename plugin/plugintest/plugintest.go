@@ -0,0 +1,77 @@
+// Package plugintest runs parasites in the test process, so that their
+// handlers, the handshake and the calls to the host can be tested with go
+// test, race detector included, without building executables.
+package plugintest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/delichik/go-pkgs/plugin"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+// ExecutorFunc is a plugin.Executor answering the calls of the parasite with
+// a function.
+type ExecutorFunc func(call string, data []byte) ([]byte, error)
+
+func (f ExecutorFunc) OnCall(call string, data []byte) ([]byte, error) {
+	return f(call, data)
+}
+
+// New starts a host with hostOptions and loads a parasite serving the
+// handlers registered in the test process with options, through in-memory
+// pipes. Missing host names and versions are filled so that the host and the
+// parasite accept each other, and calls to a host without Executor fail. The
+// host is shut down when the test ends.
+func New(t testing.TB, options *plugin.Options, hostOptions *plugin.HostOptions) *plugin.Host {
+	t.Helper()
+	parasiteOptions := *options
+	if hostOptions == nil {
+		hostOptions = &plugin.HostOptions{}
+	}
+	hostCopy := *hostOptions
+	if hostCopy.Name == "" {
+		hostCopy.Name = parasiteOptions.HostName
+	}
+	if hostCopy.Name == "" {
+		hostCopy.Name = "plugintest"
+	}
+	if parasiteOptions.HostName == "" {
+		parasiteOptions.HostName = hostCopy.Name
+	}
+	if hostCopy.Version == "" {
+		hostCopy.Version = parasiteOptions.HostMinimalVersion
+	}
+	if hostCopy.Version == "" {
+		hostCopy.Version = "0.0.0"
+	}
+	if parasiteOptions.HostMinimalVersion == "" && parasiteOptions.HostVersionConstraint == "" {
+		parasiteOptions.HostMinimalVersion = "0.0.0"
+	}
+	if hostCopy.Executor == nil {
+		hostCopy.Executor = ExecutorFunc(func(call string, data []byte) ([]byte, error) {
+			return nil, fmt.Errorf("plugintest: the host has no executor for %s", call)
+		})
+	}
+
+	h := plugin.NewHostWithOptions(&hostCopy)
+	err := h.LoadFunc(parasiteOptions.Name, func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error {
+		return plugin.ServeParasite(ctx, r, w, handshake, &parasiteOptions)
+	})
+	if err != nil {
+		t.Fatalf("plugintest: load parasite %s: %v", parasiteOptions.Name, err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := h.Shutdown(ctx); err != nil {
+			t.Errorf("plugintest: shut down: %v", err)
+		}
+	})
+	return h
+}
@@ -0,0 +1,90 @@
+package plugintest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/delichik/go-pkgs/plugin"
+)
+
+type greeter struct {
+	initialized bool
+}
+
+func (g *greeter) Init() error {
+	g.initialized = true
+	return nil
+}
+
+func (g *greeter) UnInit() {}
+
+func (g *greeter) Handle(data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	greeting, err := plugin.CallHostWithResponse(ctx, "greeting", nil)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(string(greeting) + " " + string(data)), nil
+}
+
+type sum struct {
+	A, B int
+}
+
+func init() {
+	plugin.RegisterHandler("greet", &greeter{})
+	plugin.Register("sum", func(ctx context.Context, req sum) (int, error) {
+		if req.A < 0 {
+			return 0, errors.New("negative")
+		}
+		return req.A + req.B, nil
+	})
+}
+
+func TestNew(t *testing.T) {
+	h := New(t, &plugin.Options{Name: "test", Version: "1.0.0", Secret: []byte("secret")}, &plugin.HostOptions{
+		Executor: ExecutorFunc(func(call string, data []byte) ([]byte, error) {
+			return []byte("hello"), nil
+		}),
+		Secret: []byte("secret"),
+	})
+
+	rsp, err := h.Call("greet", []byte("world"))
+	if err != nil || string(rsp) != "hello world" {
+		t.Errorf("unexpected reply %q %v", rsp, err)
+	}
+
+	e, ok := h.Parasite("test")
+	if !ok {
+		t.Fatal("the parasite should be loaded")
+	}
+	result, err := plugin.Invoke[sum, int](e, "sum", sum{A: 1, B: 2})
+	if err != nil || result != 3 {
+		t.Errorf("unexpected result %d %v", result, err)
+	}
+	_, err = plugin.Invoke[sum, int](e, "sum", sum{A: -1})
+	var remoteErr *plugin.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Message != "negative" {
+		t.Errorf("the error of the handler should be returned, got %v", err)
+	}
+}
+
+func TestNew_refused(t *testing.T) {
+	h := plugin.NewHostWithOptions(&plugin.HostOptions{Name: "other", Version: "1.0.0"})
+	err := h.LoadFunc("test", func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error {
+		return plugin.ServeParasite(ctx, r, w, handshake, &plugin.Options{
+			Name:               "test",
+			Version:            "1.0.0",
+			HostName:           "host",
+			HostMinimalVersion: "1.0.0",
+		})
+	})
+	if !errors.Is(err, plugin.ErrHostRefused) || !strings.Contains(err.Error(), "other") {
+		t.Errorf("the parasite should refuse the host, got %v", err)
+	}
+}
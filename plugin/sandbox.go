@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Sandbox restricts the processes of a parasite. The limits, the process
+// group and the namespaces are only supported on Linux, where the limits are
+// set by the host executable run in place of the parasite, before it executes
+// the parasite: the parasite and the processes it starts never run without
+// them.
+type Sandbox struct {
+	// CPUTime limits the CPU time of the process, rounded up to seconds.
+	CPUTime time.Duration
+	// AddressSpace limits the virtual memory of the process, in bytes.
+	AddressSpace uint64
+	// OpenFiles limits the number of files the process may open.
+	OpenFiles uint64
+
+	// WorkingDir overrides the working directory of the manifest.
+	WorkingDir string
+	// ScrubEnv starts the process with only the variables of the host named
+	// in KeepEnv, instead of the whole environment of the host. Env is added
+	// either way, then the variables of the manifest.
+	ScrubEnv bool
+	KeepEnv  []string
+	Env      map[string]string
+
+	// ProcessGroup runs the process in its own process group, so that the
+	// processes it starts are terminated and killed along with it.
+	ProcessGroup bool
+	// Namespaces runs the process in new user, mount, PID, IPC and UTS
+	// namespaces, as root of the user namespace, and IsolateNetwork in a new
+	// network namespace too. No privilege is needed where unprivileged user
+	// namespaces are enabled.
+	Namespaces     bool
+	IsolateNetwork bool
+}
+
+// sandbox returns the sandbox of the named parasite, nil if it runs
+// unrestricted.
+func (h *Host) sandbox(name string) *Sandbox {
+	if s, ok := h.options.Sandboxes[name]; ok {
+		return s
+	}
+	return h.options.Sandbox
+}
+
+// environ returns the environment of the process, nil to inherit the one of
+// the host.
+func (s *Sandbox) environ() []string {
+	if s == nil {
+		return nil
+	}
+	if !s.ScrubEnv && len(s.Env) == 0 {
+		return nil
+	}
+	env := []string{}
+	if s.ScrubEnv {
+		for _, name := range s.KeepEnv {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+	} else {
+		env = os.Environ()
+	}
+	for k, v := range s.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// apply sets up cmd before it starts.
+func (s *Sandbox) apply(cmd *exec.Cmd) error {
+	if s == nil {
+		return nil
+	}
+	if s.WorkingDir != "" {
+		cmd.Dir = s.WorkingDir
+	}
+	if err := s.applyLimits(cmd); err != nil {
+		return err
+	}
+	return s.applySysProcAttr(cmd)
+}
+
+func (s *Sandbox) hasLimits() bool {
+	return s != nil && (s.CPUTime > 0 || s.AddressSpace > 0 || s.OpenFiles > 0)
+}
+
+// cpuSeconds rounds CPUTime up to seconds.
+func (s *Sandbox) cpuSeconds() uint64 {
+	return uint64((s.CPUTime + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func (s *Sandbox) applySysProcAttr(cmd *exec.Cmd) error {
+	if !s.ProcessGroup && !s.Namespaces && !s.IsolateNetwork {
+		return nil
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Setpgid = s.ProcessGroup
+	if s.Namespaces || s.IsolateNetwork {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		if s.IsolateNetwork {
+			attr.Cloneflags |= syscall.CLONE_NEWNET
+		}
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+	return nil
+}
+
+func init() {
+	limits, ok := os.LookupEnv(envSandboxLimits)
+	if !ok {
+		return
+	}
+	err := execLimited(limits, os.Args[1:])
+	fmt.Fprintln(os.Stderr, "fail to run parasite:", err)
+	os.Exit(127)
+}
+
+// applyLimits runs cmd through the host executable, which sets the resource
+// limits on itself before it executes the parasite in the same process.
+func (s *Sandbox) applyLimits(cmd *exec.Cmd) error {
+	if !s.hasLimits() {
+		return nil
+	}
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_CPU, s.cpuSeconds()},
+		{syscall.RLIMIT_AS, s.AddressSpace},
+		{syscall.RLIMIT_NOFILE, s.OpenFiles},
+	}
+	pairs := []string{}
+	for _, l := range limits {
+		if l.value != 0 {
+			pairs = append(pairs, fmt.Sprintf("%d=%d", l.resource, l.value))
+		}
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, envSandboxLimits+"="+strings.Join(pairs, ","))
+	// the host executable gets the path of the parasite, then its arguments
+	cmd.Args = append([]string{cmd.Args[0], cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	return nil
+}
+
+// execLimited sets limits and executes args[0] with the arguments args[1:].
+func execLimited(limits string, args []string) error {
+	if len(args) < 2 {
+		return errors.New("no parasite to execute")
+	}
+	for _, pair := range strings.Split(limits, ",") {
+		resource, value, _ := strings.Cut(pair, "=")
+		r, err := strconv.Atoi(resource)
+		if err != nil {
+			return fmt.Errorf("parse resource limit %q: %w", pair, err)
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse resource limit %q: %w", pair, err)
+		}
+		// the syscall package is told about the limit of the open files so
+		// that it does not restore the one of the host on exec
+		if err = syscall.Setrlimit(r, &syscall.Rlimit{Cur: v, Max: v}); err != nil {
+			return fmt.Errorf("set resource limit %d: %w", r, err)
+		}
+	}
+	if err := os.Unsetenv(envSandboxLimits); err != nil {
+		return err
+	}
+	return syscall.Exec(args[0], args[1:], os.Environ())
+}
+
+// signalGroup sends sig to the process group led by pid.
+func signalGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig)
+}
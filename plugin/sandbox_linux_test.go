@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSandbox_limits(t *testing.T) {
+	// cat is a child of sh, which the host executable runs with the limits
+	cmd := exec.Command("sh", "-c", "cat /proc/self/limits")
+	s := &Sandbox{CPUTime: 1500 * time.Millisecond, OpenFiles: 64, AddressSpace: 1 << 30}
+	if err := s.apply(cmd); err != nil {
+		t.Fatal(err)
+	}
+	limits, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range [][]string{
+		{"Max cpu time", "2", "2"},
+		{"Max open files", "64", "64"},
+		{"Max address space", "1073741824", "1073741824"},
+	} {
+		if soft, hard := readLimit(limits, expected[0]); soft != expected[1] || hard != expected[2] {
+			t.Errorf("%s should be limited to %s in\n%s", expected[0], expected[1], limits)
+		}
+	}
+}
+
+// readLimit returns the soft and hard values of the named limit in the
+// content of a /proc/<pid>/limits file.
+func readLimit(limits []byte, name string) (string, string) {
+	for _, line := range strings.Split(string(limits), "\n") {
+		if strings.HasPrefix(line, name) {
+			fields := strings.Fields(strings.TrimPrefix(line, name))
+			if len(fields) >= 2 {
+				return fields[0], fields[1]
+			}
+		}
+	}
+	return "", ""
+}
+
+func TestSandbox_processGroup(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 30 & echo $!; wait")
+	s := &Sandbox{ProcessGroup: true}
+	if err := s.apply(cmd); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _ := strconv.Atoi(strings.TrimSpace(line))
+
+	r := &cmdRunner{cmd: cmd, group: true}
+	if err = r.kill(); err != nil {
+		t.Fatal(err)
+	}
+	r.wait()
+	deadline := time.Now().Add(time.Second)
+	for processAlive(child) {
+		if time.Now().After(deadline) {
+			t.Fatal("the child of the parasite should be killed with it")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// processAlive reports whether pid runs and is not a zombie.
+func processAlive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestSandbox_namespaces(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "echo $$ $(id -u)")
+	s := &Sandbox{Namespaces: true, IsolateNetwork: true}
+	if err := s.apply(cmd); err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSPC) {
+		t.Skipf("user namespaces are not available: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(out)) != "1 0" {
+		t.Errorf("the process should be pid 1 and root in its namespaces, got %q", out)
+	}
+}
+
+func init() {
+	testParasites["limited"] = func(options *Options) {
+		RegisterHandler("echo", handlerFunc(func(data []byte) ([]byte, error) {
+			return data, nil
+		}))
+	}
+}
+
+func TestHost_sandboxLimits(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", Sandbox: &Sandbox{OpenFiles: 64}})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	err = h.loadManifest(&Manifest{
+		Name:       "limited",
+		Executable: executable,
+		Env:        map[string]string{envTestParasite: "limited"},
+		dir:        t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp, err := h.CallParasite("limited", "echo", []byte("data")); err != nil || string(rsp) != "data" {
+		t.Errorf("the parasite should be served over its pipe, got %q %v", rsp, err)
+	}
+
+	e, _ := h.Parasite("limited")
+	pid := strconv.Itoa(e.proc.runner.(*cmdRunner).cmd.Process.Pid)
+	limits, err := os.ReadFile("/proc/" + pid + "/limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if soft, hard := readLimit(limits, "Max open files"); soft != "64" || hard != "64" {
+		t.Errorf("the parasite should run with its limits\n%s", limits)
+	}
+	environ, err := os.ReadFile("/proc/" + pid + "/environ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(environ), envSandboxLimits) {
+		t.Error("the limits should not be passed to the parasite")
+	}
+}
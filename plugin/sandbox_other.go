@@ -0,0 +1,29 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+var errSandboxUnsupported = errors.New("sandbox is only supported on linux")
+
+func (s *Sandbox) applySysProcAttr(cmd *exec.Cmd) error {
+	if s.ProcessGroup || s.Namespaces || s.IsolateNetwork {
+		return errSandboxUnsupported
+	}
+	return nil
+}
+
+func (s *Sandbox) applyLimits(cmd *exec.Cmd) error {
+	if s.hasLimits() {
+		return errSandboxUnsupported
+	}
+	return nil
+}
+
+func signalGroup(pid int, sig syscall.Signal) error {
+	return errSandboxUnsupported
+}
@@ -0,0 +1,28 @@
+package plugin
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSandbox_environ(t *testing.T) {
+	t.Setenv("PLUGIN_TEST_KEEP", "keep")
+	t.Setenv("PLUGIN_TEST_DROP", "drop")
+
+	var s *Sandbox
+	if s.environ() != nil {
+		t.Error("no sandbox should inherit the environment")
+	}
+
+	s = &Sandbox{ScrubEnv: true, KeepEnv: []string{"PLUGIN_TEST_KEEP", "PLUGIN_TEST_MISSING"}, Env: map[string]string{"A": "1"}}
+	env := s.environ()
+	if len(env) != 2 || env[0] != "PLUGIN_TEST_KEEP=keep" || env[1] != "A=1" {
+		t.Errorf("unexpected environment %v", env)
+	}
+
+	s = &Sandbox{Env: map[string]string{"A": "1"}}
+	env = s.environ()
+	if len(env) != len(os.Environ())+1 || env[len(env)-1] != "A=1" {
+		t.Errorf("the environment of the host should be kept, got %d variables", len(env))
+	}
+}
@@ -0,0 +1,267 @@
+package plugin
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// streamWindow is how many messages a stream buffers on the receiving side.
+// The sender waits for the receiver to consume them before it sends more.
+const streamWindow = 16
+
+var (
+	ErrStreamClosed   = errors.New("stream closed")
+	errStreamOverflow = errors.New("stream receive window overflow")
+)
+
+// StreamHandler serves a stream opened by the host, see
+// RegisterStreamHandler. The error it returns is reported to the host once
+// the handler returns.
+type StreamHandler func(stream *Stream) error
+
+// Stream is a call exchanging any number of messages in both directions,
+// multiplexed with the other calls over the pipe of a parasite.
+//
+// For a server-streaming call the host sends the request and calls CloseSend,
+// then calls Recv until it returns io.EOF. For a client-streaming call the
+// host calls Send for each message, then CloseAndRecv for the reply.
+type Stream struct {
+	id     uint64
+	call   string
+	conn   *conn
+	client bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	recv     chan []byte
+	recvDone chan struct{}
+	recvErr  error
+	recvOnce sync.Once
+	consumed int
+
+	credits    chan struct{}
+	sendClosed atomic.Bool
+	// done is closed once the stream is over: for the host when the handler
+	// has returned, for the parasite when its handler returns
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newStream(ctx context.Context, id uint64, call string, conn *conn, client bool) *Stream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Stream{
+		id:       id,
+		call:     call,
+		conn:     conn,
+		client:   client,
+		ctx:      ctx,
+		cancel:   cancel,
+		recv:     make(chan []byte, streamWindow),
+		recvDone: make(chan struct{}),
+		credits:  make(chan struct{}, streamWindow),
+		done:     make(chan struct{}),
+	}
+	for i := 0; i < streamWindow; i++ {
+		s.credits <- struct{}{}
+	}
+	return s
+}
+
+// Call returns the name of the call the stream was opened for.
+func (s *Stream) Call() string {
+	return s.call
+}
+
+// Context is done when the stream is canceled by the host or the parasite
+// exits.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Send sends a message, waiting while the receiver has not consumed enough of
+// the messages sent before.
+func (s *Stream) Send(data []byte) error {
+	if s.sendClosed.Load() {
+		return ErrStreamClosed
+	}
+	select {
+	case <-s.credits:
+	case <-s.done:
+		return ErrStreamClosed
+	case <-s.ctx.Done():
+		return contextError(s.ctx.Err())
+	}
+	return s.conn.send(&sendObject{
+		id:      s.id,
+		call:    callStreamData,
+		content: data,
+	})
+}
+
+// CloseSend tells the other side no more messages are sent. The sending side
+// of a StreamHandler is closed when it returns, so that the error it returns
+// reaches the host.
+func (s *Stream) CloseSend() error {
+	if s.sendClosed.Swap(true) || !s.client {
+		return nil
+	}
+	return s.conn.send(&sendObject{
+		id:   s.id,
+		call: callStreamEnd,
+	})
+}
+
+// Recv returns the next message. It returns io.EOF once the other side has
+// closed its sending side, or the error the handler of the parasite
+// returned.
+func (s *Stream) Recv() ([]byte, error) {
+	for {
+		// the messages received before the end are returned first
+		select {
+		case data := <-s.recv:
+			return s.consumedOne(data), nil
+		default:
+		}
+		select {
+		case <-s.recvDone:
+			if s.recvErr != nil {
+				return nil, s.recvErr
+			}
+			return nil, io.EOF
+		default:
+		}
+		select {
+		case data := <-s.recv:
+			return s.consumedOne(data), nil
+		case <-s.recvDone:
+		case <-s.ctx.Done():
+			return nil, contextError(s.ctx.Err())
+		}
+	}
+}
+
+// CloseAndRecv closes the sending side and returns the single reply of a
+// client-streaming call.
+func (s *Stream) CloseAndRecv() ([]byte, error) {
+	if err := s.CloseSend(); err != nil {
+		return nil, err
+	}
+	return s.Recv()
+}
+
+// consumedOne gives the sender its credits back once half of the window has
+// been consumed.
+func (s *Stream) consumedOne(data []byte) []byte {
+	s.consumed++
+	if s.consumed >= streamWindow/2 {
+		credit := binary.BigEndian.AppendUint32(nil, uint32(s.consumed))
+		s.consumed = 0
+		_ = s.conn.send(&sendObject{
+			id:      s.id,
+			call:    callStreamCredit,
+			content: credit,
+		})
+	}
+	return data
+}
+
+// deliver is called by the reading goroutine of the pipe with the frames of
+// the stream.
+func (s *Stream) deliver(r *sendObject) {
+	switch r.call {
+	case callStreamData:
+		select {
+		case s.recv <- r.content:
+		default:
+			s.end(errStreamOverflow)
+			s.cancel()
+		}
+	case callStreamCredit:
+		if len(r.content) != 4 {
+			return
+		}
+		for n := binary.BigEndian.Uint32(r.content); n > 0; n-- {
+			select {
+			case s.credits <- struct{}{}:
+			default:
+				return
+			}
+		}
+	case callStreamEnd:
+		var err error
+		if r.remoteErr != nil {
+			err = r.remoteErr
+		}
+		s.end(err)
+		if s.client {
+			s.finish()
+		}
+	}
+}
+
+// end marks the receiving side as closed with err, nil for io.EOF.
+func (s *Stream) end(err error) {
+	s.recvOnce.Do(func() {
+		s.recvErr = err
+		close(s.recvDone)
+	})
+}
+
+func (s *Stream) finish() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// abort ends the stream on the local side only, when the pipe is gone.
+func (s *Stream) abort(err error) {
+	s.end(err)
+	s.finish()
+	s.cancel()
+}
+
+// streamSet tracks the streams open over a pipe by id.
+type streamSet struct {
+	streams map[uint64]*Stream
+	locker  sync.Mutex
+}
+
+func (set *streamSet) add(s *Stream) {
+	set.locker.Lock()
+	defer set.locker.Unlock()
+	if set.streams == nil {
+		set.streams = make(map[uint64]*Stream)
+	}
+	set.streams[s.id] = s
+}
+
+func (set *streamSet) get(id uint64) (*Stream, bool) {
+	set.locker.Lock()
+	defer set.locker.Unlock()
+	s, ok := set.streams[id]
+	return s, ok
+}
+
+func (set *streamSet) remove(id uint64) {
+	set.locker.Lock()
+	defer set.locker.Unlock()
+	delete(set.streams, id)
+}
+
+func (set *streamSet) abortAll(err error) {
+	set.locker.Lock()
+	defer set.locker.Unlock()
+	for id, s := range set.streams {
+		s.abort(err)
+		delete(set.streams, id)
+	}
+}
+
+func isStreamFrame(call string) bool {
+	return call == callStreamData || call == callStreamEnd || call == callStreamCredit
+}
@@ -0,0 +1,243 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func init() {
+	testParasites["streaming"] = func(options *Options) {
+		// count sends the numbers below the one it gets
+		RegisterStreamHandler("count", func(stream *Stream) error {
+			req, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(string(req))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				if err = stream.Send([]byte(strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		// sum replies with the sum of the numbers it gets
+		RegisterStreamHandler("sum", func(stream *Stream) error {
+			sum := 0
+			for {
+				req, err := stream.Recv()
+				if err == io.EOF {
+					return stream.Send([]byte(strconv.Itoa(sum)))
+				}
+				if err != nil {
+					return err
+				}
+				n, err := strconv.Atoi(string(req))
+				if err != nil {
+					return err
+				}
+				sum += n
+			}
+		})
+		RegisterHandler("video_stream", handlerFunc(func(data []byte) ([]byte, error) {
+			return append([]byte("video/"), data...), nil
+		}))
+	}
+}
+
+// newStreamPair connects a host side and a parasite side stream over pipes.
+func newStreamPair(t *testing.T) (*Stream, *Stream) {
+	hostReader, parasiteWriter := io.Pipe()
+	parasiteReader, hostWriter := io.Pipe()
+	t.Cleanup(func() {
+		_ = parasiteWriter.Close()
+		_ = hostWriter.Close()
+	})
+	client := newStream(context.Background(), 1, "test", newConn(hostReader, hostWriter), true)
+	server := newStream(context.Background(), 1, "test", newConn(parasiteReader, parasiteWriter), false)
+	for _, s := range []*Stream{client, server} {
+		go func(s *Stream) {
+			for {
+				r, err := s.conn.read()
+				if err != nil {
+					return
+				}
+				s.deliver(r)
+			}
+		}(s)
+	}
+	return client, server
+}
+
+func TestStream_serverStreaming(t *testing.T) {
+	client, server := newStreamPair(t)
+	go func() {
+		req, err := server.Recv()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		n, _ := strconv.Atoi(string(req))
+		for i := 0; i < n; i++ {
+			if err = server.Send([]byte(strconv.Itoa(i))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		_ = server.conn.send(&sendObject{id: server.id, call: callStreamEnd})
+	}()
+
+	if err := client.Send([]byte("100")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		data, err := client.Recv()
+		if err == io.EOF {
+			if i != 100 {
+				t.Errorf("expected 100 messages, got %d", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != strconv.Itoa(i) {
+			t.Fatalf("expected message %d, got %s", i, data)
+		}
+	}
+	if err := client.Send(nil); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("send after the end should fail, got %v", err)
+	}
+}
+
+func TestStream_clientStreaming(t *testing.T) {
+	client, server := newStreamPair(t)
+	go func() {
+		count := 0
+		for {
+			_, err := server.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			count++
+		}
+		_ = server.Send([]byte(strconv.Itoa(count)))
+		_ = server.conn.send(&sendObject{
+			id:        server.id,
+			call:      callStreamEnd,
+			remoteErr: toRemoteError(ErrHandlerPanic),
+		})
+	}()
+
+	for i := 0; i < 50; i++ {
+		if err := client.Send([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rsp, err := client.CloseAndRecv()
+	if err != nil || string(rsp) != "50" {
+		t.Fatalf("expected 50, got %s %v", rsp, err)
+	}
+	if _, err = client.Recv(); !errors.Is(err, ErrHandlerPanic) {
+		t.Errorf("the error of the handler should be returned, got %v", err)
+	}
+}
+
+func TestStream_flowControl(t *testing.T) {
+	client, server := newStreamPair(t)
+	sent := make(chan int, 100)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := client.Send([]byte("x")); err != nil {
+				return
+			}
+			sent <- i
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if len(sent) != streamWindow {
+		t.Fatalf("the sender should stop after %d messages, sent %d", streamWindow, len(sent))
+	}
+	for i := 0; i < streamWindow/2; i++ {
+		if _, err := server.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(sent) != streamWindow+streamWindow/2 {
+		t.Errorf("the sender should get credits back, sent %d", len(sent))
+	}
+}
+
+func TestEntity_OpenStream(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	loadTestParasites(t, h, "streaming")
+	e, ok := h.Parasite("streaming")
+	if !ok {
+		t.Fatal("the parasite should be loaded")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := e.ServerStream(ctx, "count", []byte("100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		data, err := s.Recv()
+		if err == io.EOF {
+			if i != 100 {
+				t.Errorf("expected 100 messages, got %d", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != strconv.Itoa(i) {
+			t.Fatalf("expected message %d, got %s", i, data)
+		}
+	}
+
+	s, err = e.OpenStream(ctx, "sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		if err = s.Send([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rsp, err := s.CloseAndRecv(); err != nil || string(rsp) != "55" {
+		t.Errorf("expected 55, got %s %v", rsp, err)
+	}
+
+	s, err = e.OpenStream(ctx, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Recv(); !errors.Is(err, ErrUnknownCall) {
+		t.Errorf("a stream without handler should fail with ErrUnknownCall, got %v", err)
+	}
+
+	// a plain call is not taken for a stream whatever its name
+	rsp, err := e.CallWithResponseContext(ctx, "video_stream", []byte("data"))
+	if err != nil || string(rsp) != "video/data" {
+		t.Errorf("the call should reach its handler, got %q %v", rsp, err)
+	}
+}
@@ -0,0 +1,233 @@
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/delichik/daf/logger"
+	"go.uber.org/zap"
+)
+
+// RestartPolicy decides how a host restarts parasites that exit on their own.
+// Zero durations take the value of DefaultRestartPolicy.
+type RestartPolicy struct {
+	// MaxRestarts is how many times in a row a parasite is restarted before
+	// the host gives up on it, 0 never restarts.
+	MaxRestarts int
+	// InitialBackoff is the delay before the first restart, doubled for each
+	// following one up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// ResetAfter is how long a parasite has to keep running for its restarts
+	// to be counted from zero again.
+	ResetAfter time.Duration
+}
+
+var DefaultRestartPolicy = RestartPolicy{
+	MaxRestarts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	ResetAfter:     time.Minute,
+}
+
+func (p *RestartPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	if delay <= 0 {
+		delay = DefaultRestartPolicy.InitialBackoff
+	}
+	maxDelay := p.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = DefaultRestartPolicy.MaxBackoff
+	}
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (p *RestartPolicy) resetAfter() time.Duration {
+	if p.ResetAfter <= 0 {
+		return DefaultRestartPolicy.ResetAfter
+	}
+	return p.ResetAfter
+}
+
+type EventType int
+
+const (
+	EventStarted EventType = iota + 1
+	EventExited
+	EventRestarting
+	EventGaveUp
+	// EventUnhealthy and EventHealthy are sent when a parasite stops and
+	// starts answering the health checks again.
+	EventUnhealthy
+	EventHealthy
+	// EventFailed is sent when a process of the parasite fails to start,
+	// including when its Init fails.
+	EventFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStarted:
+		return "started"
+	case EventExited:
+		return "exited"
+	case EventRestarting:
+		return "restarting"
+	case EventGaveUp:
+		return "gave_up"
+	case EventUnhealthy:
+		return "unhealthy"
+	case EventHealthy:
+		return "healthy"
+	case EventFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Event reports a change in the life or the health of a parasite process.
+type Event struct {
+	Type     EventType
+	Parasite string
+	// ExitCode is set for EventExited, -1 when the process was killed by a
+	// signal.
+	ExitCode int
+	// Attempt and Delay are set for EventRestarting.
+	Attempt int
+	Delay   time.Duration
+	// Err is set for EventFailed.
+	Err error
+}
+
+type subscribers struct {
+	fns    map[uint64]func(*Event)
+	nextID uint64
+	locker sync.RWMutex
+}
+
+// Subscribe calls fn with every lifecycle event of the parasites until the
+// returned function is called. fn is called from the goroutine watching the
+// parasite and must not block.
+func (h *Host) Subscribe(fn func(*Event)) func() {
+	h.subscribers.locker.Lock()
+	defer h.subscribers.locker.Unlock()
+	if h.subscribers.fns == nil {
+		h.subscribers.fns = make(map[uint64]func(*Event))
+	}
+	h.subscribers.nextID++
+	id := h.subscribers.nextID
+	h.subscribers.fns[id] = fn
+	return func() {
+		h.subscribers.locker.Lock()
+		defer h.subscribers.locker.Unlock()
+		delete(h.subscribers.fns, id)
+	}
+}
+
+func (h *Host) emit(event *Event) {
+	h.subscribers.locker.RLock()
+	defer h.subscribers.locker.RUnlock()
+	for _, fn := range h.subscribers.fns {
+		fn(event)
+	}
+}
+
+func (h *Host) restartPolicy() *RestartPolicy {
+	if h.options.RestartPolicy != nil {
+		return h.options.RestartPolicy
+	}
+	return &DefaultRestartPolicy
+}
+
+// supervise watches proc and restarts the parasite when it exits without
+// Stop or Shutdown being called. The calls waiting for the exited process
+// fail with ErrParasiteExited.
+func (e *Entity) supervise(proc *process) {
+	restarts := 0
+	for {
+		exitCode := proc.wait()
+		e.procLocker.Lock()
+		if e.proc == proc {
+			e.proc = nil
+		}
+		e.procLocker.Unlock()
+		e.pending.failAll(ErrParasiteExited)
+		e.streams.abortAll(ErrParasiteExited)
+		e.host.emit(&Event{Type: EventExited, Parasite: e.name, ExitCode: exitCode})
+		if e.stopping.Load() || e.attached {
+			// an attached parasite connects again on its own
+			e.host.remove(e)
+			e.cancel()
+			return
+		}
+		logger.Warn("parasite exited", zap.String("parasite_name", e.name), zap.Int("exit_code", exitCode))
+
+		policy := e.host.restartPolicy()
+		if time.Since(proc.startTime) >= policy.resetAfter() {
+			restarts = 0
+		}
+		proc = nil
+		for proc == nil {
+			if e.stopping.Load() {
+				// stopped while the parasite was restarting
+				e.host.remove(e)
+				e.cancel()
+				return
+			}
+			if restarts >= policy.MaxRestarts {
+				logger.Error("parasite keeps exiting, giving up", zap.String("parasite_name", e.name))
+				e.host.emit(&Event{Type: EventGaveUp, Parasite: e.name})
+				e.host.remove(e)
+				e.cancel()
+				return
+			}
+			delay := policy.backoff(restarts)
+			restarts++
+			e.host.emit(&Event{Type: EventRestarting, Parasite: e.name, Attempt: restarts, Delay: delay})
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-e.ctx.Done():
+				timer.Stop()
+				e.host.remove(e)
+				return
+			}
+
+			var err error
+			proc, err = e.startProcess()
+			if err != nil {
+				logger.Error("fail to restart parasite", zap.String("parasite_name", e.name), zap.Error(err))
+				if errors.Is(err, ErrParasiteRefused) || errors.Is(err, ErrHostRefused) {
+					// restarting the same binary will not change the versions
+					e.host.emit(&Event{Type: EventGaveUp, Parasite: e.name})
+					e.host.remove(e)
+					e.cancel()
+					return
+				}
+				continue
+			}
+			if e.stopping.Load() {
+				// stopped while the new process was starting
+				_ = proc.runner.kill()
+				proc.wait()
+				e.procLocker.Lock()
+				if e.proc == proc {
+					e.proc = nil
+				}
+				e.procLocker.Unlock()
+				e.host.remove(e)
+				e.cancel()
+				return
+			}
+			e.restarted()
+			e.host.emit(&Event{Type: EventStarted, Parasite: e.name})
+		}
+	}
+}
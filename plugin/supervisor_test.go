@@ -0,0 +1,285 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRestartPolicy_backoff(t *testing.T) {
+	policy := &RestartPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for attempt, delay := range expected {
+		if d := policy.backoff(attempt); d != delay {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, delay, d)
+		}
+	}
+
+	if d := (&RestartPolicy{}).backoff(0); d != DefaultRestartPolicy.InitialBackoff {
+		t.Errorf("should use the default backoff, got %s", d)
+	}
+}
+
+func init() {
+	testParasites["crashing"] = func(options *Options) {
+		RegisterHandler("wait", crashing{})
+		RegisterHandler("echo", handlerFunc(func(data []byte) ([]byte, error) {
+			return data, nil
+		}))
+	}
+}
+
+// crashing records its Inits and the calls it waits for until they are
+// canceled.
+type crashing struct{}
+
+func (crashing) Init() error {
+	recordEvent("init")
+	return nil
+}
+
+func (crashing) UnInit() {}
+
+func (crashing) Handle(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (crashing) HandleContext(ctx context.Context, data []byte) ([]byte, error) {
+	recordEvent("waiting")
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// eventRecorder records the types of the events of a host.
+type eventRecorder struct {
+	types  []EventType
+	locker sync.Mutex
+}
+
+func (r *eventRecorder) record(event *Event) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.types = append(r.types, event.Type)
+}
+
+func (r *eventRecorder) count(eventType EventType) int {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	n := 0
+	for _, t := range r.types {
+		if t == eventType {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *eventRecorder) get() []EventType {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return append([]EventType(nil), r.types...)
+}
+
+func TestEntity_supervise(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{
+		Name:          "host",
+		Version:       "1.0.0",
+		RestartPolicy: &RestartPolicy{MaxRestarts: 2, InitialBackoff: time.Millisecond},
+	})
+	events := &eventRecorder{}
+	h.Subscribe(events.record)
+	dir := loadTestParasites(t, h, "crashing")
+	e, ok := h.Parasite("crashing")
+	if !ok {
+		t.Fatal("the parasite should be loaded")
+	}
+	// the process is killed behind the back of the host
+	crash := func() {
+		t.Helper()
+		e.procLocker.RLock()
+		proc := e.proc
+		e.procLocker.RUnlock()
+		if err := proc.runner.kill(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := e.CallWithResponse("wait", nil)
+		errs <- err
+	}()
+	waitFor(t, "the call to be handled", func() bool {
+		return countEvents(dir, "waiting") == 1
+	})
+	crash()
+	if err := <-errs; !errors.Is(err, ErrParasiteExited) {
+		t.Errorf("the pending call should fail with ErrParasiteExited, got %v", err)
+	}
+
+	waitFor(t, "the parasite to be restarted", func() bool {
+		return events.count(EventStarted) == 2
+	})
+	if n := countEvents(dir, "init"); n != 2 {
+		t.Errorf("Init should run again, got %d inits", n)
+	}
+	if rsp, err := e.CallWithResponse("echo", []byte("data")); err != nil || string(rsp) != "data" {
+		t.Errorf("the restarted parasite should serve the calls, got %q %v", rsp, err)
+	}
+
+	crash()
+	waitFor(t, "the parasite to be restarted again", func() bool {
+		return events.count(EventStarted) == 3
+	})
+	crash()
+	waitFor(t, "the host to give up", func() bool {
+		return events.count(EventGaveUp) == 1
+	})
+	if _, ok := h.Parasite("crashing"); ok {
+		t.Error("the parasite should be removed once the host gives up")
+	}
+	if n := countEvents(dir, "init"); n != 3 {
+		t.Errorf("the parasite should be restarted MaxRestarts times, got %d runs", n)
+	}
+
+	expected := []EventType{
+		EventStarted,
+		EventExited, EventRestarting, EventStarted,
+		EventExited, EventRestarting, EventStarted,
+		EventExited, EventGaveUp,
+	}
+	got := events.get()
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected events %v", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("unexpected events %v", got)
+		}
+	}
+}
+
+// crashingParasite serves parasite with LoadFunc, its process exits without
+// waiting for its calls when crash is called.
+type crashingParasite struct {
+	crashes chan struct{}
+	runs    atomic.Int32
+	served  atomic.Int32
+}
+
+func loadCrashingParasite(t *testing.T, h *Host, name string, parasite ParasiteV2, calls ...string) *crashingParasite {
+	t.Helper()
+	handlers := newRegistry()
+	handlers.register(parasite, calls)
+	options := &Options{Name: name, Version: "1.0.0", HostName: "host", HostMinimalVersion: "1.0.0"}
+	checkOptions(options)
+
+	p := &crashingParasite{crashes: make(chan struct{})}
+	serve := func(ctx context.Context, r io.Reader, w io.Writer, handshake string) error {
+		p.runs.Add(1)
+		defer p.served.Add(1)
+		stop := make(chan struct{})
+		go func() {
+			select {
+			case <-p.crashes:
+			case <-ctx.Done():
+			}
+			close(stop)
+		}()
+		conn := newConn(r, w)
+		hostInfo, err := checkHandshake(handshake, options)
+		if err != nil {
+			return err
+		}
+		return serveParasite(conn, &HostClient{conn: conn}, hostInfo, options, handlers, stop, stop)
+	}
+	if err := h.LoadFunc(name, serve); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func (p *crashingParasite) crash() {
+	p.crashes <- struct{}{}
+}
+
+// slowRestart blocks the Init of every process but the first one until
+// release is closed.
+type slowRestart struct {
+	inits    atomic.Int32
+	starting chan struct{}
+	release  chan struct{}
+}
+
+func (p *slowRestart) Init(ctx context.Context) error {
+	if p.inits.Add(1) == 1 {
+		return nil
+	}
+	p.starting <- struct{}{}
+	<-p.release
+	return nil
+}
+
+func (p *slowRestart) Handle(ctx context.Context, call string, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (p *slowRestart) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestEntity_Shutdown_whileRestarting(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{
+		Name:          "host",
+		Version:       "1.0.0",
+		RestartPolicy: &RestartPolicy{MaxRestarts: 3, InitialBackoff: time.Millisecond},
+	})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	parasite := &slowRestart{starting: make(chan struct{}), release: make(chan struct{})}
+	p := loadCrashingParasite(t, h, "slow", parasite, "echo")
+	e, _ := h.Parasite("slow")
+	var started atomic.Int32
+	h.Subscribe(func(event *Event) {
+		if event.Type == EventStarted {
+			started.Add(1)
+		}
+	})
+
+	p.crash()
+	<-parasite.starting
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(parasite.release)
+
+	waitFor(t, "the new process to be stopped", func() bool {
+		return p.served.Load() == 2
+	})
+	waitFor(t, "the parasite to be removed", func() bool {
+		_, ok := h.Parasite("slow")
+		return !ok
+	})
+	time.Sleep(50 * time.Millisecond)
+	if runs := p.runs.Load(); runs != 2 {
+		t.Errorf("the parasite should not be restarted again, got %d runs", runs)
+	}
+	if n := started.Load(); n != 0 {
+		t.Errorf("the new process should not be started, got %d events", n)
+	}
+}
@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/delichik/daf/logger"
+	"github.com/vmihailenco/msgpack"
+	"go.uber.org/zap"
+)
+
+// maxHandshakeLine bounds the handshake line a parasite reads from the host.
+const maxHandshakeLine = 64 << 10
+
+// Transport carries the frames between the host and a parasite the host does
+// not run itself, such as a net.Conn of a parasite that connected to the
+// host. The host starts by writing the handshake it passes to the parasites
+// it runs with -h, followed by a line break, then frames are exchanged as
+// over the pipes of a parasite process.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// transportRunner is the runner of an attached parasite, which runs until
+// its transport is closed. It reads from the transport for the host to
+// notice when it is closed.
+type transportRunner struct {
+	t          Transport
+	closed     chan struct{}
+	closedOnce sync.Once
+}
+
+func (r *transportRunner) Read(p []byte) (int, error) {
+	n, err := r.t.Read(p)
+	if err != nil {
+		r.closedOnce.Do(func() {
+			close(r.closed)
+		})
+	}
+	return n, err
+}
+
+func (r *transportRunner) wait() int {
+	<-r.closed
+	_ = r.t.Close()
+	return 0
+}
+
+func (r *transportRunner) terminate() error {
+	return r.t.Close()
+}
+
+func (r *transportRunner) kill() error {
+	return r.t.Close()
+}
+
+// transportSpawner runs the parasite at the other end of t, which is only
+// started once. The entity is named after the handshake of the parasite.
+func transportSpawner(t Transport) spawner {
+	return func(e *Entity, handshake string) (*process, error) {
+		r := &transportRunner{
+			t:      t,
+			closed: make(chan struct{}),
+		}
+		if _, err := io.WriteString(t, handshake+"\n"); err != nil {
+			_ = t.Close()
+			return nil, err
+		}
+
+		c := newConn(r, t)
+		if d, ok := t.(interface{ SetReadDeadline(time.Time) error }); ok {
+			_ = d.SetReadDeadline(time.Now().Add(handshakeTimeout))
+			defer d.SetReadDeadline(time.Time{})
+		}
+		first, err := c.peek()
+		if err != nil {
+			_ = t.Close()
+			return nil, fmt.Errorf("%w: %w", ErrHandshake, err)
+		}
+		if first.call == callHandshake && first.remoteErr == nil {
+			info := &HandshakeInfo{}
+			if err = msgpack.Unmarshal(first.content, info); err == nil {
+				// no other goroutine uses the entity before it is started
+				e.name = info.Name
+			}
+		}
+		return &process{
+			runner: r,
+			conn:   c,
+			exited: make(chan struct{}),
+		}, nil
+	}
+}
+
+// Attach runs the parasite at the other end of t, named after the handshake
+// it sends back. It is not restarted once t is closed, the parasite is
+// expected to connect again. It replaces and drains a parasite with the same
+// name that connected before it, but is refused if the host runs that
+// parasite itself unless HostOptions.AttachReplaces is set.
+func (h *Host) Attach(t Transport) error {
+	e := newEntity("", transportSpawner(t), h)
+	e.attached = true
+	return e.Start()
+}
+
+// Serve attaches the parasites connecting to l, see Attach and DialHost,
+// until l is closed. A host listening on TCP should authenticate its
+// parasites with HostOptions.Secret or HostOptions.TrustedKeys.
+func (h *Host) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := h.Attach(c); err != nil {
+				logger.Error("fail to attach parasite", zap.String("remote_addr", c.RemoteAddr().String()),
+					zap.Error(err))
+			}
+		}()
+	}
+}
+
+// DialHost connects to a host serving parasites on network and address with
+// Host.Serve, and serves it the registered handlers as ServeParasite does
+// until ctx is done or the host closes the connection.
+func DialHost(ctx context.Context, network string, address string, options *Options) error {
+	c, err := (&net.Dialer{}).DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	handshake, err := readHandshake(c)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrHandshake, err)
+	}
+	return ServeParasite(ctx, c, c, handshake, options)
+}
+
+// readHandshake reads the handshake line byte by byte, so that nothing after
+// it is consumed before the frames are read.
+func readHandshake(r io.Reader) (string, error) {
+	line := strings.Builder{}
+	b := make([]byte, 1)
+	for line.Len() < maxHandshakeLine {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(line.String(), "\r"), nil
+		}
+		line.WriteByte(b[0])
+	}
+	return "", bufio.ErrTooLong
+}
+
+// parseConnect splits the value of the -connect flag of a parasite, such as
+// "unix:///run/host.sock" or "tcp://127.0.0.1:7000".
+func parseConnect(connect string) (string, string, error) {
+	network, address, ok := strings.Cut(connect, "://")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("malformed host address %s", connect)
+	}
+	return network, address, nil
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHost_Serve(t *testing.T) {
+	for _, network := range []string{"unix", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			address := "127.0.0.1:0"
+			if network == "unix" {
+				address = filepath.Join(t.TempDir(), "host.sock")
+			}
+			l, err := net.Listen(network, address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", Secret: []byte("secret")})
+			served := make(chan error, 1)
+			go func() {
+				served <- h.Serve(l)
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			dialed := make(chan error, 1)
+			go func() {
+				dialed <- DialHost(ctx, network, l.Addr().String(), &Options{
+					Name:               "remote",
+					Version:            "1.0.0",
+					HostName:           "host",
+					HostMinimalVersion: "1.0.0",
+					Secret:             []byte("secret"),
+				})
+			}()
+			waitFor(t, "the parasite to attach", func() bool {
+				_, ok := h.Parasite("remote")
+				return ok
+			})
+
+			rsp, err := h.Call("echo", []byte("hello"))
+			if err != nil || string(rsp) != "hello" {
+				t.Errorf("unexpected reply %q %v", rsp, err)
+			}
+
+			// the parasite leaves, the host forgets it without restarting it
+			cancel()
+			if err = <-dialed; err != nil {
+				t.Errorf("the parasite should shut down cleanly, got %v", err)
+			}
+			waitFor(t, "the parasite to be removed", func() bool {
+				_, ok := h.Parasite("remote")
+				return !ok
+			})
+
+			_ = l.Close()
+			if err = <-served; err != nil {
+				t.Errorf("Serve should return once the listener is closed, got %v", err)
+			}
+		})
+	}
+}
+
+// attachTestParasite attaches a parasite serving the handlers registered in
+// the test process with options to h over an in-memory connection.
+func attachTestParasite(h *Host, options *Options) error {
+	hostSide, parasiteSide := net.Pipe()
+	go func() {
+		defer parasiteSide.Close()
+		handshake, err := readHandshake(parasiteSide)
+		if err != nil {
+			return
+		}
+		_ = ServeParasite(context.Background(), parasiteSide, parasiteSide, handshake, options)
+	}()
+	return h.Attach(hostSide)
+}
+
+func TestHost_Attach_refused(t *testing.T) {
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", Secret: []byte("secret")})
+	err := attachTestParasite(h, &Options{
+		Name:               "remote",
+		Version:            "1.0.0",
+		HostName:           "host",
+		HostMinimalVersion: "1.0.0",
+	})
+	if err == nil || !strings.Contains(err.Error(), "proof") {
+		t.Errorf("a parasite without the secret should be refused, got %v", err)
+	}
+}
+
+func TestHost_Attach_replace(t *testing.T) {
+	options := &HostOptions{Name: "host", Version: "1.0.0"}
+	h := NewHostWithOptions(options)
+	loaded := loadTestParasite(t, h)
+	attached := &Options{
+		Name:               "test",
+		Version:            "1.0.0",
+		HostName:           "host",
+		HostMinimalVersion: "1.0.0",
+	}
+
+	err := attachTestParasite(h, attached)
+	if !errors.Is(err, ErrParasiteRefused) {
+		t.Fatalf("a parasite run by the host should not be replaced, got %v", err)
+	}
+	if e, _ := h.Parasite("test"); e != loaded {
+		t.Fatal("the loaded parasite should be kept")
+	}
+
+	options.AttachReplaces = true
+	if err = attachTestParasite(h, attached); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := h.Parasite("test"); e == loaded {
+		t.Fatal("the attached parasite should replace the loaded one")
+	}
+	waitFor(t, "the replaced parasite to be drained", func() bool {
+		return loaded.currentConn() == nil
+	})
+	if rsp, err := h.Call("echo", []byte("hello")); err != nil || string(rsp) != "hello" {
+		t.Errorf("unexpected reply %q %v", rsp, err)
+	}
+}
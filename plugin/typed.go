@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+type typedHandler[Req, Resp any] struct {
+	fn func(ctx context.Context, req Req) (Resp, error)
+}
+
+func (h *typedHandler[Req, Resp]) Init() error {
+	return nil
+}
+
+func (h *typedHandler[Req, Resp]) UnInit() {}
+
+func (h *typedHandler[Req, Resp]) Handle(data []byte) ([]byte, error) {
+	return h.HandleContext(context.Background(), data)
+}
+
+func (h *typedHandler[Req, Resp]) HandleContext(ctx context.Context, data []byte) ([]byte, error) {
+	codec := CodecFromContext(ctx)
+	var req Req
+	if err := codec.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("decode request with %s failed: %w", codec.Name(), err)
+	}
+	rsp, err := h.fn(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Marshal(rsp)
+}
+
+// Register registers fn as the handler of the call name, like
+// RegisterHandler. The request and the reply are encoded with the codec
+// negotiated with the host.
+func Register[Req, Resp any](name string, fn func(ctx context.Context, req Req) (Resp, error)) {
+	RegisterHandler(name, &typedHandler[Req, Resp]{fn: fn})
+}
+
+// Invoke calls a handler registered with Register on the parasite and waits
+// for the reply for at most the default timeout of the host.
+func Invoke[Req, Resp any](e *Entity, name string, req Req) (Resp, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.host.defaultTimeout())
+	defer cancel()
+	return InvokeContext[Req, Resp](ctx, e, name, req)
+}
+
+// InvokeContext is Invoke bounded by ctx instead of the default timeout.
+func InvokeContext[Req, Resp any](ctx context.Context, e *Entity, name string, req Req) (Resp, error) {
+	var rsp Resp
+	codec := e.Codec()
+	data, err := codec.Marshal(req)
+	if err != nil {
+		return rsp, fmt.Errorf("encode request with %s failed: %w", codec.Name(), err)
+	}
+	data, err = e.CallWithResponseContext(ctx, name, data)
+	if err != nil {
+		return rsp, err
+	}
+	if err = codec.Unmarshal(data, &rsp); err != nil {
+		return rsp, fmt.Errorf("decode reply with %s failed: %w", codec.Name(), err)
+	}
+	return rsp, nil
+}
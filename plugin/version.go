@@ -0,0 +1,274 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version as described on https://semver.org. A
+// leading "v" is accepted and missing minor or patch numbers are zero.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      string
+}
+
+func ParseVersion(s string) (*Version, error) {
+	v, _, err := parseVersion(s)
+	return v, err
+}
+
+// parseVersion also returns how many of the major, minor and patch numbers
+// are given, which ranges such as "^0.2" and "1.2" depend on.
+func parseVersion(s string) (*Version, int, error) {
+	v := &Version{}
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Build) {
+			return nil, 0, fmt.Errorf("invalid version %q: bad build metadata", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		pre := rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(pre) {
+			return nil, 0, fmt.Errorf("invalid version %q: bad pre-release", s)
+		}
+		v.Prerelease = strings.Split(pre, ".")
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return nil, 0, fmt.Errorf("invalid version %q: too many numbers", s)
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid version %q: %q is not a number", s, part)
+		}
+		*numbers[i] = n
+	}
+	return v, len(parts), nil
+}
+
+func validIdentifiers(s string) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0 or 1 as v is lower than, equal to or greater than o.
+// Build metadata is ignored.
+func (v *Version) Compare(o *Version) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	// a pre-release is lower than the release itself
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareIdentifier compares pre-release identifiers, numeric ones
+// numerically and lower than alphanumeric ones.
+func compareIdentifier(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return compareUint(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+type comparator struct {
+	op      string
+	version *Version
+}
+
+func (c *comparator) check(v *Version) bool {
+	r := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		// "<2" does not let the pre-releases of 2.0.0 in
+		return r < 0 && !(len(c.version.Prerelease) == 0 && len(v.Prerelease) > 0 &&
+			v.Major == c.version.Major && v.Minor == c.version.Minor && v.Patch == c.version.Patch)
+	case "<=":
+		return r <= 0
+	}
+	return false
+}
+
+// VersionConstraint is a set of version ranges such as ">=1.2 <2 || ^3.1".
+// The comparators of a range, separated by spaces or commas, must all match,
+// and any of the ranges separated by "||" may match. Besides =, !=, >, >=, <
+// and <=, "^1.2" allows versions up to the next major version (minor for 0.x),
+// "~1.2" up to the next minor version, and a bare partial version such as
+// "1.2" any version with the same prefix.
+type VersionConstraint struct {
+	raw    string
+	ranges [][]*comparator
+}
+
+func ParseVersionConstraint(s string) (*VersionConstraint, error) {
+	c := &VersionConstraint{raw: s}
+	for _, rangeStr := range strings.Split(s, "||") {
+		tokens := strings.Fields(strings.ReplaceAll(rangeStr, ",", " "))
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("invalid version constraint %q: empty range", s)
+		}
+		comparators := []*comparator{}
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+			// allow a space between the operator and the version
+			if strings.Trim(token, "=!<>^~") == "" && i+1 < len(tokens) {
+				i++
+				token += tokens[i]
+			}
+			parsed, err := parseComparator(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %w", s, err)
+			}
+			comparators = append(comparators, parsed...)
+		}
+		c.ranges = append(c.ranges, comparators)
+	}
+	return c, nil
+}
+
+// parseComparator parses a single comparator, expanding ^, ~ and partial
+// versions into a lower and an upper bound.
+func parseComparator(s string) ([]*comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, parts, err := parseVersion(s[len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	// the index of the number bumped for the upper bound
+	bump := -1
+	switch op {
+	case "^":
+		switch {
+		case v.Major > 0 || parts == 1:
+			bump = 0
+		case v.Minor > 0 || parts == 2:
+			bump = 1
+		default:
+			bump = 2
+		}
+	case "~":
+		bump = 1
+		if parts == 1 {
+			bump = 0
+		}
+	case "", "=":
+		if parts == 3 {
+			return []*comparator{{op: "=", version: v}}, nil
+		}
+		bump = parts - 1
+	default:
+		return []*comparator{{op: op, version: v}}, nil
+	}
+
+	upper := &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	switch bump {
+	case 0:
+		upper.Major, upper.Minor, upper.Patch = upper.Major+1, 0, 0
+	case 1:
+		upper.Minor, upper.Patch = upper.Minor+1, 0
+	case 2:
+		upper.Patch++
+	}
+	return []*comparator{{op: ">=", version: v}, {op: "<", version: upper}}, nil
+}
+
+// Check reports whether v satisfies the constraint.
+func (c *VersionConstraint) Check(v *Version) bool {
+	for _, comparators := range c.ranges {
+		ok := true
+		for _, comparator := range comparators {
+			if !comparator.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *VersionConstraint) String() string {
+	return c.raw
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestVersion_Compare(t *testing.T) {
+	ordered := []string{
+		"0.9.0",
+		"0.10.0-alpha",
+		"0.10.0-alpha.1",
+		"0.10.0-alpha.beta",
+		"0.10.0-beta.2",
+		"0.10.0-beta.11",
+		"0.10.0-rc.1",
+		"0.10.0",
+		"v1.0.0",
+		"1.2.10",
+	}
+	for i := 1; i < len(ordered); i++ {
+		lower, err := ParseVersion(ordered[i-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		greater, err := ParseVersion(ordered[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lower.Compare(greater) != -1 || greater.Compare(lower) != 1 {
+			t.Errorf("expected %s < %s", lower, greater)
+		}
+	}
+
+	a, _ := ParseVersion("1.2.3+build.5")
+	b, _ := ParseVersion("1.2.3")
+	if a.Compare(b) != 0 {
+		t.Error("build metadata should be ignored")
+	}
+	if a.String() != "1.2.3+build.5" {
+		t.Errorf("unexpected string %s", a)
+	}
+
+	for _, s := range []string{"", "1.x", "1.2.3.4", "1.2.3-", "1.2.3-a..b", "1.2.3+b_c"} {
+		if _, err := ParseVersion(s); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		ok         bool
+	}{
+		{">=1.2 <2", "1.2.0", true},
+		{">=1.2 <2", "1.10.3", true},
+		{">=1.2 <2", "1.1.9", false},
+		{">=1.2 <2", "2.0.0", false},
+		{">=1.2 <2", "2.0.0-rc.1", false},
+		{">= 1.2, < 2", "1.5.0", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"1.2", "1.2.7", true},
+		{"1.2", "1.3.0", false},
+		{"=1.2.3", "1.2.3", true},
+		{"!=1.2.3", "1.2.3", false},
+		{"<1 || >=3", "2.0.0", false},
+		{"<1 || >=3", "3.1.0", true},
+	}
+	for _, test := range tests {
+		c, err := ParseVersionConstraint(test.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := ParseVersion(test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Check(v) != test.ok {
+			t.Errorf("%s satisfies %s: expected %v", test.version, test.constraint, test.ok)
+		}
+	}
+
+	for _, s := range []string{"", ">=1.2 ||", ">>1", "^a"} {
+		if _, err := ParseVersionConstraint(s); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestCheckHandshake(t *testing.T) {
+	handshake := func(name string, version string) string {
+		data, _ := msgpack.Marshal(&HandshakeInfo{Name: name, Version: version})
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	options := &Options{HostName: "host", HostMinimalVersion: "0.9.0", HostVersionConstraint: "<1"}
+
+	if _, err := checkHandshake(handshake("host", "0.10.0"), options); err != nil {
+		t.Errorf("0.10.0 should be newer than 0.9.0: %v", err)
+	}
+	for _, test := range [][2]string{{"other", "0.10.0"}, {"host", "0.8.1"}, {"host", "1.0.0"}} {
+		if _, err := checkHandshake(handshake(test[0], test[1]), options); !errors.Is(err, ErrHostRefused) {
+			t.Errorf("%s %s should be refused, got %v", test[0], test[1], err)
+		}
+	}
+	if _, err := checkHandshake("", options); !errors.Is(err, errNoHandshake) {
+		t.Errorf("an empty handshake should be missing, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/delichik/daf/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWatchInterval = 2 * time.Second
+	// reloadDrainTimeout bounds how long a replaced or removed parasite may
+	// take to finish its calls
+	reloadDrainTimeout = 30 * time.Second
+	// watchSettleDelay is how long the files of the directory must stay
+	// unchanged after a notification before they are scanned
+	watchSettleDelay = 100 * time.Millisecond
+)
+
+// fingerprint changes whenever the executable or the manifest of the
+// parasite is modified.
+func (m *Manifest) fingerprint() string {
+	fingerprint := ""
+	for _, path := range []string{m.executablePath(), m.path} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fingerprint += "-;"
+			continue
+		}
+		fingerprint += fmt.Sprintf("%d:%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return fingerprint
+}
+
+// watcher keeps the parasites of a directory in sync with it.
+type watcher struct {
+	h   *Host
+	dir string
+	// loaded holds the fingerprints of the running parasites by name
+	loaded map[string]string
+	// changed holds the fingerprints seen on the last scan that differ from
+	// the running ones, they are acted upon once they stop changing
+	changed map[string]string
+}
+
+// Watch loads the parasites of parasitePath like Load, then scans the
+// directory every WatchInterval until ctx is done: new parasites are started,
+// parasites whose executable or manifest changed are replaced, and removed or
+// disabled parasites are shut down. A replaced parasite keeps serving the
+// calls it is handling while the new version starts, and calls to other
+// parasites are not affected. Parasites already loaded with the same name
+// are adopted.
+//
+// On Linux the directory and its subdirectories are also watched with
+// inotify, so that changes are picked up without waiting for the next scan.
+// Elsewhere, or when inotify is not available, the changes are only noticed
+// by the scans, and so are the changes of executables a manifest names
+// outside of the directory.
+func (h *Host) Watch(ctx context.Context, parasitePath string) error {
+	w := &watcher{
+		h:       h,
+		dir:     parasitePath,
+		loaded:  map[string]string{},
+		changed: map[string]string{},
+	}
+	manifests, _, err := h.readManifests(parasitePath)
+	if err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		if !m.enabled() || !h.selected(m.Name) {
+			continue
+		}
+		fingerprint := m.fingerprint()
+		if _, ok := h.Parasite(m.Name); !ok {
+			if err := h.loadManifest(m); err != nil {
+				// loaded again by the next scans
+				logger.Error("fail to load parasite", zap.String("name", m.Name), zap.Error(err))
+				continue
+			}
+		}
+		w.loaded[m.Name] = fingerprint
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changes, err := notifyChanges(ctx, parasitePath)
+	if err != nil {
+		logger.Warn("fail to watch parasite directory, only scanning it",
+			zap.String("dir", parasitePath), zap.Error(err))
+	}
+
+	interval := h.options.WatchInterval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	settle := time.NewTimer(watchSettleDelay)
+	settle.Stop()
+	defer settle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.scan()
+		case <-changes:
+			// a parasite may still be being copied
+			settle.Reset(watchSettleDelay)
+		case <-settle.C:
+			w.scan()
+			// a change is acted upon once a second scan sees it unchanged
+			if len(w.changed) > 0 {
+				settle.Reset(watchSettleDelay)
+			}
+		}
+	}
+}
+
+func (w *watcher) scan() {
+	manifests, broken, err := w.h.readManifests(w.dir)
+	if err != nil {
+		logger.Error("fail to scan parasites", zap.String("dir", w.dir), zap.Error(err))
+		return
+	}
+
+	// the parasites of the manifests that can not be read are left as they
+	// are until the manifests can be read again
+	seen := map[string]bool{}
+	for _, name := range broken {
+		seen[name] = true
+	}
+	for _, m := range manifests {
+		if !m.enabled() || !w.h.selected(m.Name) {
+			continue
+		}
+		seen[m.Name] = true
+		fingerprint := m.fingerprint()
+		if w.loaded[m.Name] == fingerprint {
+			delete(w.changed, m.Name)
+			continue
+		}
+		// the executable may still be being copied
+		if w.changed[m.Name] != fingerprint {
+			w.changed[m.Name] = fingerprint
+			continue
+		}
+		delete(w.changed, m.Name)
+		if w.h.reload(m) {
+			w.loaded[m.Name] = fingerprint
+		}
+	}
+
+	for name := range w.loaded {
+		if seen[name] {
+			continue
+		}
+		delete(w.loaded, name)
+		delete(w.changed, name)
+		if e, ok := w.h.Parasite(name); ok {
+			logger.Info("unload parasite", zap.String("name", name))
+			go w.h.drain(e)
+		}
+	}
+}
+
+// reload starts the parasite of m, which drains the previous version once it
+// serves the calls, and reports whether the new one started. The previous
+// version keeps running if the new one fails to start.
+func (h *Host) reload(m *Manifest) bool {
+	_, ok := h.Parasite(m.Name)
+	if err := h.loadManifest(m); err != nil {
+		logger.Error("fail to reload parasite", zap.String("name", m.Name), zap.Error(err))
+		return false
+	}
+	if ok {
+		logger.Info("parasite reloaded", zap.String("name", m.Name))
+	}
+	return true
+}
+
+func (h *Host) drain(e *Entity) {
+	ctx, cancel := context.WithTimeout(context.Background(), reloadDrainTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		logger.Warn("fail to shut parasite down", zap.String("name", e.name), zap.Error(err))
+	}
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const notifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// notifyChanges signals on the returned channel when a file of dir, or of one
+// of its subdirectories, changes, until ctx is done.
+func notifyChanges(ctx context.Context, dir string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// a non-blocking descriptor is read through the poller, so that closing
+	// the file ends the pending read
+	f := os.NewFile(uintptr(fd), "inotify")
+	raw, err := f.SyscallConn()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	watch := func(path string) (wd int, err error) {
+		controlErr := raw.Control(func(fd uintptr) {
+			wd, err = syscall.InotifyAddWatch(int(fd), path, notifyMask)
+		})
+		if controlErr != nil {
+			return 0, controlErr
+		}
+		return wd, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	top, err := watch(dir)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			_, _ = watch(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		_ = f.Close()
+	}()
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				name := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+				// the subdirectories created later are watched as well
+				if event.Wd == int32(top) && event.Mask&syscall.IN_ISDIR != 0 &&
+					event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					_, _ = watch(filepath.Join(dir, eventName(name)))
+				}
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// eventName returns the name of an inotify event, which is padded with NULs.
+func eventName(name []byte) string {
+	for i, b := range name {
+		if b == 0 {
+			return string(name[:i])
+		}
+	}
+	return string(name)
+}
@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotifyChanges(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := notifyChanges(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChange := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s should be notified", what)
+		}
+		// the events of the same change come in several reads
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case <-changes:
+		default:
+		}
+	}
+
+	sub := filepath.Join(dir, "p")
+	if err = os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("a new subdirectory")
+	if err = os.WriteFile(filepath.Join(sub, "plugin.yaml"), []byte("name: p\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("a file written in a new subdirectory")
+	if err = os.Remove(filepath.Join(sub, "plugin.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("a removed file")
+}
+
+func TestHost_Watch_notify(t *testing.T) {
+	dir := t.TempDir()
+	ready := filepath.Join(t.TempDir(), "ready")
+	if err := os.WriteFile(ready, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeWatchedParasite(t, filepath.Join(dir, "p"), ready, "")
+	// only the notifications make the parasites change during the test
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", WatchInterval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	watched := make(chan error, 1)
+	go func() {
+		watched <- h.Watch(ctx, dir)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-watched
+		_ = h.Shutdown(context.Background())
+	})
+	waitFor(t, "the parasite to be loaded", func() bool {
+		_, ok := h.Parasite("p")
+		return ok
+	})
+	// the directory is watched once the parasites it holds are loaded
+	time.Sleep(50 * time.Millisecond)
+
+	if err := os.Remove(filepath.Join(dir, "p")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the removed parasite to be unloaded", func() bool {
+		_, ok := h.Parasite("p")
+		return !ok
+	})
+}
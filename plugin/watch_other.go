@@ -0,0 +1,13 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"context"
+)
+
+// notifyChanges returns no notifications, Watch only notices the changes
+// when it scans the directory.
+func notifyChanges(ctx context.Context, dir string) (<-chan struct{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func init() {
+	// the watched parasite only needs to start
+	testParasites["p"] = func(options *Options) {}
+}
+
+// writeWatchedParasite writes an executable at path running the test binary
+// as a parasite once the file at ready exists, and failing to start before.
+func writeWatchedParasite(t *testing.T, path string, ready string, comment string) {
+	t.Helper()
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\n# %s\n[ -e %q ] || exit 1\n%s=%s exec %q \"$@\"\n",
+		comment, ready, envTestParasite, filepath.Base(path), executable)
+	if err = os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher_scan(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the parasite is a shell script")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "p")
+	ready := filepath.Join(t.TempDir(), "ready")
+	writeWatchedParasite(t, path, ready, "first")
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0"})
+	t.Cleanup(func() {
+		_ = h.Shutdown(context.Background())
+	})
+	w := &watcher{
+		h:       h,
+		dir:     dir,
+		loaded:  map[string]string{},
+		changed: map[string]string{},
+	}
+
+	w.scan()
+	w.scan()
+	if _, ok := w.loaded["p"]; ok {
+		t.Fatal("a parasite failing to start should not be recorded as loaded")
+	}
+	if err := os.WriteFile(ready, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.scan()
+	w.scan()
+	fingerprint, ok := w.loaded["p"]
+	if !ok {
+		t.Fatal("a new parasite should be loaded")
+	}
+	first, _ := h.Parasite("p")
+
+	writeWatchedParasite(t, path, ready, "second")
+	w.scan()
+	if w.loaded["p"] != fingerprint {
+		t.Error("a changed parasite should not be reloaded before it settles")
+	}
+	w.scan()
+	if w.loaded["p"] == fingerprint || len(w.changed) != 0 {
+		t.Error("a settled parasite should be reloaded")
+	}
+	if second, _ := h.Parasite("p"); second == first {
+		t.Error("the new version should replace the previous one")
+	}
+
+	manifest := filepath.Join(dir, "p.plugin.yaml")
+	if err := os.WriteFile(manifest, []byte("name: ["), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.scan()
+	if _, ok := w.loaded["p"]; !ok {
+		t.Error("a parasite whose manifest can not be read should be kept")
+	}
+	if err := os.Remove(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	w.scan()
+	if len(w.loaded) != 0 {
+		t.Error("a removed parasite should be unloaded")
+	}
+}
+
+func TestHost_Watch_retry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the parasite is a shell script")
+	}
+	dir := t.TempDir()
+	ready := filepath.Join(t.TempDir(), "ready")
+	writeWatchedParasite(t, filepath.Join(dir, "p"), ready, "")
+	h := NewHostWithOptions(&HostOptions{Name: "host", Version: "1.0.0", WatchInterval: 10 * time.Millisecond})
+	failed := make(chan struct{}, 1)
+	h.Subscribe(func(event *Event) {
+		if event.Type == EventFailed {
+			select {
+			case failed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	watched := make(chan error, 1)
+	go func() {
+		watched <- h.Watch(ctx, dir)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-watched
+		_ = h.Shutdown(context.Background())
+	})
+
+	<-failed
+	if err := os.WriteFile(ready, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the files of the parasite did not change since it failed to start
+	waitFor(t, "the parasite to be loaded again", func() bool {
+		_, ok := h.Parasite("p")
+		return ok
+	})
+}